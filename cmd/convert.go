@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	v1 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v1"
+	v2 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v2"
+	v3 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v3"
+	v4 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v4"
+
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Convert a v1.0 or v2.0 config file into the v3.0 format
+func convertCommand(args []string) int {
+	var output string
+
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, may be repeated (default is $HOME/.mongodb_query_exporter/config.yaml)")
+	flags.StringVarP(&output, "output", "o", "", "write the converted config to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	name, err := readConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		return 1
+	}
+
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		defer f.Close()
+		w = f
+	}
+
+	if err := convert(name, w); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		return 1
+	}
+
+	return 0
+}
+
+// Write the config in the v3.0 format, split configs are written as a single config.
+// The files are read without flags and environment variables so these are not written to the converted config.
+func convert(name string, w io.Writer) error {
+	v := viper.New()
+	v.SetConfigType("yaml")
+
+	if configFiles != nil {
+		b, err := yaml.Marshal(configFiles.Settings)
+		if err != nil {
+			return err
+		}
+
+		if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+			return err
+		}
+	} else {
+		v.SetConfigFile(name)
+		if err := v.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	conf, err := decodeConfig(v, configFileNames())
+	if err != nil {
+		return err
+	}
+
+	var converted *v3.Config
+	switch conf := conf.(type) {
+	case *v1.Config:
+		converted = v3.FromV1(conf)
+	case *v2.Config:
+		converted = v3.FromV2(conf)
+	case *v3.Config:
+		converted = conf
+	case *v4.Config:
+		converted = &conf.Config
+	default:
+		return fmt.Errorf("unsupported config %T", conf)
+	}
+
+	if _, err := fmt.Fprintln(w, "version: 3.0"); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(converted); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Print the effective config with the global defaults applied, without connecting to any server
+func effectiveCommand(args []string) int {
+	flags := flag.NewFlagSet("effective", flag.ContinueOnError)
+	flags.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, may be repeated (default is $HOME/.mongodb_query_exporter/config.yaml)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, err := readConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return effective(os.Stdout, os.Stderr)
+}
+
+func effective(stdout, stderr io.Writer) int {
+	c, _, err := buildCollector()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer func() {
+		_ = c.Shutdown(context.Background())
+	}()
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c.EffectiveConfig()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
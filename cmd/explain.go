@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Thresholds which make the explain command fail
+type explainThresholds struct {
+	maxDocsExamined int64
+	maxKeysExamined int64
+	failOnCollscan  bool
+}
+
+// Explain the aggregations and report their winning plans and examined documents
+func explainCommand(args []string) int {
+	var (
+		filter     collector.Filter
+		thresholds explainThresholds
+		timeout    time.Duration
+	)
+
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, may be repeated (default is $HOME/.mongodb_query_exporter/config.yaml)")
+	flags.StringSliceVar(&filter.Aggregations, "aggregation", nil, "Only explain the aggregations with the given names")
+	flags.StringSliceVar(&filter.Groups, "group", nil, "Only explain the aggregations of the given groups")
+	flags.Int64Var(&thresholds.maxDocsExamined, "max-docs-examined", 0, "Fail if an aggregation examines more documents, 0 disables the check")
+	flags.Int64Var(&thresholds.maxKeysExamined, "max-keys-examined", 0, "Fail if an aggregation examines more index keys, 0 disables the check")
+	flags.BoolVar(&thresholds.failOnCollscan, "fail-on-collscan", false, "Fail if the winning plan of an aggregation contains a collection scan")
+	flags.DurationVar(&timeout, "timeout", time.Minute, "Time to wait for server connections and all explains")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, err := readConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	c, _, err := buildCollector()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_ = c.StartServerMonitor()
+	defer func() {
+		_ = c.Shutdown(context.Background())
+	}()
+
+	return explain(c.Explain(ctx, filter), thresholds, os.Stdout)
+}
+
+func explain(results []collector.ExplainResult, thresholds explainThresholds, w io.Writer) int {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no aggregations matched")
+		return 1
+	}
+
+	code := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AGGREGATION\tSERVER\tNAMESPACE\tPLAN\tINDEXES\tDOCS EXAMINED\tKEYS EXAMINED\tRETURNED\tDURATION\tFAILURE")
+
+	for _, result := range results {
+		failure := thresholds.check(result)
+		if failure != "" {
+			code = 1
+		}
+
+		ns := "-"
+		if result.Database != "" || result.Collection != "" {
+			ns = result.Database + "." + result.Collection
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			result.Aggregation, result.Server, ns, strings.Join(result.Stages, " > "), strings.Join(result.Indexes, ","),
+			result.DocsExamined, result.KeysExamined, result.Returned, result.Duration, failure)
+	}
+
+	_ = tw.Flush()
+	return code
+}
+
+// Return why the result exceeds the thresholds or an empty string
+func (t explainThresholds) check(result collector.ExplainResult) string {
+	switch {
+	case result.Err != nil:
+		return result.Err.Error()
+	case t.failOnCollscan && result.CollectionScan:
+		return "collection scan"
+	case t.maxDocsExamined > 0 && result.DocsExamined > t.maxDocsExamined:
+		return fmt.Sprintf("more than %d documents examined", t.maxDocsExamined)
+	case t.maxKeysExamined > 0 && result.KeysExamined > t.maxKeysExamined:
+		return fmt.Sprintf("more than %d keys examined", t.maxKeysExamined)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	v4 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v4"
+)
+
+// Check all config files for unknown keys, errors are reported with the file name if the config is split across files
+func strictFiles(names []string) error {
+	var errs config.ValidationErrors
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		err = v4.Strict(b)
+
+		var fileErrs config.ValidationErrors
+		switch {
+		case err == nil:
+		case errors.As(err, &fileErrs):
+			for _, e := range fileErrs {
+				if len(names) > 1 {
+					e.File = name
+				}
+
+				errs = append(errs, e)
+			}
+		default:
+			return err
+		}
+	}
+
+	return errs.Err()
+}
+
+// Move a validation error of a merged config into the file it has been defined in
+func locate(err *config.ValidationError) {
+	if configFiles != nil && err.File == "" {
+		err.File, err.Path = configFiles.Locate(err.Path)
+	}
+}
+
+// Call onChange whenever a file within the directories of the config sources changes.
+// Directories are watched instead of files to notice added files and files replaced by a rename (like kubernetes configmaps).
+func watchConfigSources(paths []string, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		dir := path
+		if info, err := os.Stat(path); strings.ContainsAny(path, "*?[") || err != nil || !info.IsDir() {
+			dir = filepath.Dir(path)
+		}
+
+		if dirs[dir] {
+			continue
+		}
+
+		dirs[dir] = true
+		if err := w.Add(dir); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case _, ok := <-w.Events:
+				if !ok {
+					return
+				}
+
+				onChange()
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
@@ -13,109 +21,310 @@ import (
 	v1 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v1"
 	v2 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v2"
 	v3 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v3"
+	v4 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v4"
 
+	"github.com/go-kit/log"
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	configPath    string
-	logLevel      string
-	logEncoding   string
-	bind          string
-	uri           string
-	metricsPath   string
-	queryTimeout  time.Duration
-	srv           *http.Server
-	promCollector *collector.Collector
+	configPaths     []string
+	configFiles     *config.Files
+	logLevel        string
+	logEncoding     string
+	bind            string
+	uri             string
+	metricsPath     string
+	queryTimeout    time.Duration
+	scrapeOffset    time.Duration
+	readiness       string
+	webConfigFile   string
+	watchConfig     bool
+	shutdownTimeout time.Duration
+	explainInterval time.Duration
+	configEndpoint  bool
+	srv             *http.Server
+	promCollector   *collector.Collector
 )
 
+// Subcommands, the exporter is started if none is given
+var commands = map[string]func(args []string) int{
+	"convert":   convertCommand,
+	"validate":  validateCommand,
+	"dry-run":   dryRunCommand,
+	"effective": effectiveCommand,
+	"explain":   explainCommand,
+	"schema":    schemaCommand,
+	"run":       dryRunCommand,
+	"test":      testCommand,
+}
+
+// Header set by prometheus containing the scrape timeout in seconds
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// Response of the readiness endpoint
+type readyzResponse struct {
+	Ready bool `json:"ready"`
+	collector.Status
+}
+
 func init() {
 	flag.StringVarP(&uri, "uri", "u", config.DefaultMongoDBURI, "MongoDB URI (default is mongodb://localhost:27017). Use MDBEXPORTER_SERVER_%d_MONGODB_URI envs if you target multiple server")
-	flag.StringVarP(&configPath, "file", "f", "", "config file (default is $HOME/.mongodb_query_exporter/config.yaml)")
+	flag.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, may be repeated (default is $HOME/.mongodb_query_exporter/config.yaml)")
 	flag.StringVarP(&logLevel, "log-level", "l", config.DefaultLogLevel, "Define the log level (default is warning) [debug,info,warn,error]")
 	flag.StringVarP(&logEncoding, "log-encoding", "e", config.DefaultLogEncoder, "Define the log format (default is json) [json,console]")
 	flag.StringVarP(&bind, "bind", "b", config.DefaultBindAddr, "Address to bind http server (default is :9412)")
 	flag.StringVarP(&metricsPath, "path", "p", config.DefaultMetricsPath, "Metric path (default is /metrics)")
 	flag.DurationVarP(&queryTimeout, "query-timeout", "t", config.DefaultQueryTimeout, "Timeout for MongoDB queries")
+	flag.DurationVar(&scrapeOffset, "scrape-timeout-offset", config.DefaultScrapeTimeoutOffset, "Offset to subtract from the prometheus scrape timeout to finish a scrape in time")
+	flag.StringVar(&webConfigFile, "web.config.file", "", "Path to a web config file enabling TLS and basic auth (exporter-toolkit format)")
+	flag.BoolVar(&watchConfig, "watch-config", false, "Reload the configuration as soon as the config file changes")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", config.DefaultShutdownTimeout, "Time to wait for running scrapes and background tasks on shutdown")
+	flag.DurationVar(&explainInterval, "explain-interval", 0, "Periodically explain all aggregations and export the examined documents and keys, disabled by default")
+	flag.BoolVar(&configEndpoint, "config-endpoint", false, "Serve the resolved configuration with redacted credentials on /config")
+	flag.StringVar(&readiness, "readiness", collector.ReadinessAll, "Readiness mode, either all servers must be up or any [all,any]")
 
-	_ = viper.BindPFlag("log.level", flag.Lookup("log-level"))
-	_ = viper.BindPFlag("log.encoding", flag.Lookup("log-encoding"))
-	_ = viper.BindPFlag("bind", flag.Lookup("bind"))
-	_ = viper.BindPFlag("metricsPath", flag.Lookup("path"))
-	_ = viper.BindPFlag("mongodb.uri", flag.Lookup("uri"))
-	_ = viper.BindPFlag("mongodb.queryTimeout", flag.Lookup("query-timeout"))
-	_ = viper.BindEnv("mongodb.uri", "MDBEXPORTER_MONGODB_URI")
-	_ = viper.BindEnv("global.queryTimeout", "MDBEXPORTER_MONGODB_QUERY_TIMEOUT")
-	_ = viper.BindEnv("log.level", "MDBEXPORTER_LOG_LEVEL")
-	_ = viper.BindEnv("log.encoding", "MDBEXPORTER_LOG_ENCODING")
-	_ = viper.BindEnv("bind", "MDBEXPORTER_BIND")
-	_ = viper.BindEnv("metricsPath", "MDBEXPORTER_METRICSPATH")
+	bindConfig(viper.GetViper())
+
+	prometheus.MustRegister(config.ConfigLastReloadSuccessful, config.ConfigLastReloadSuccessTimestamp)
+	prometheus.MustRegister(config.Explain.DocsExamined, config.Explain.KeysExamined, config.Explain.CollectionScan)
+}
+
+// Bind the flags and env variables which override settings of the config file
+func bindConfig(v *viper.Viper) {
+	_ = v.BindPFlag("log.level", flag.Lookup("log-level"))
+	_ = v.BindPFlag("log.encoding", flag.Lookup("log-encoding"))
+	_ = v.BindPFlag("bind", flag.Lookup("bind"))
+	_ = v.BindPFlag("metricsPath", flag.Lookup("path"))
+	_ = v.BindPFlag("mongodb.uri", flag.Lookup("uri"))
+	_ = v.BindPFlag("mongodb.queryTimeout", flag.Lookup("query-timeout"))
+	_ = v.BindEnv("mongodb.uri", "MDBEXPORTER_MONGODB_URI")
+	_ = v.BindEnv("global.queryTimeout", "MDBEXPORTER_MONGODB_QUERY_TIMEOUT")
+	_ = v.BindEnv("log.level", "MDBEXPORTER_LOG_LEVEL")
+	_ = v.BindEnv("log.encoding", "MDBEXPORTER_LOG_ENCODING")
+	_ = v.BindEnv("bind", "MDBEXPORTER_BIND")
+	_ = v.BindEnv("metricsPath", "MDBEXPORTER_METRICSPATH")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
+	// Repeated config paths are appended to the previous ones, main may be run more than once (like in tests)
+	configPaths = nil
 	flag.Parse()
 	initConfig()
 
+	if env := os.Getenv("MDBEXPORTER_READINESS"); env != "" {
+		readiness = env
+	}
+
+	if _, err := (collector.Status{}).Ready(readiness); err != nil {
+		panic(err)
+	}
+
 	c, conf, err := buildCollector()
 	if err != nil {
 		panic(err)
 	}
 
-	prometheus.MustRegister(c)
 	promCollector = c
+	startTasks(c)
+	webConfig, cleanup, err := buildWebConfig(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	defer cleanup()
+
+	r := newReloader(prometheus.DefaultGatherer, c, conf, configFileNames())
+	config.ConfigLastReloadSuccessful.Set(1)
+	config.ConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+	r.watch()
+
+	srv = &http.Server{Addr: conf.GetBindAddr(), Handler: r}
+	systemdSocket := false
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- web.ListenAndServe(srv, &web.FlagConfig{
+			WebListenAddresses: &[]string{conf.GetBindAddr()},
+			WebSystemdSocket:   &systemdSocket,
+			WebConfigFile:      &webConfig,
+		}, log.NewLogfmtLogger(os.Stderr))
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case err = <-serveErr:
+		// Only panic if we have a net error
+		if _, ok := err.(*net.OpError); ok {
+			panic(err)
+		} else {
+			os.Stderr.WriteString(err.Error() + "\n")
+		}
+	case <-sig:
+		if err := shutdown(r); err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			cleanup()
+			os.Exit(1)
+		}
+	}
+}
+
+// Stop accepting scrapes, wait for running scrapes and stop the collector including its change streams
+// and server connections. Everything must finish within the shutdown timeout.
+func shutdown(r *reloader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var result error
+	if err := srv.Shutdown(ctx); err != nil {
+		result = multierror.Append(result, fmt.Errorf("failed to shutdown http server: %w", err))
+	}
+
+	if err := r.Shutdown(ctx); err != nil {
+		result = multierror.Append(result, fmt.Errorf("failed to shutdown collector: %w", err))
+	}
+
+	return result
+}
+
+// Start the background tasks of a collector
+func startTasks(c *collector.Collector) {
+	_ = c.StartServerMonitor()
 	_ = c.StartCacheInvalidator()
-	srv = buildHTTPServer(prometheus.DefaultGatherer, conf)
-	err = srv.ListenAndServe()
 
-	// Only panic if we have a net error
-	if _, ok := err.(*net.OpError); ok {
-		panic(err)
-	} else {
-		os.Stderr.WriteString(err.Error() + "\n")
+	if explainInterval > 0 {
+		_ = c.StartExplainer(explainInterval, config.Explain)
 	}
 }
 
 func buildCollector() (*collector.Collector, config.Config, error) {
+	return buildCollectorFrom(viper.GetViper(), configFileNames())
+}
+
+// Build the collector from the config read by the given viper from the named files
+func buildCollectorFrom(v *viper.Viper, names []string) (*collector.Collector, config.Config, error) {
+	conf, err := loadConfigFrom(v, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := conf.Build()
+	return c, conf, err
+}
+
+// Decode the config read by viper into the format of its version
+func loadConfig() (config.Config, error) {
+	return loadConfigFrom(viper.GetViper(), configFileNames())
+}
+
+// Decode the config read by the given viper from the named files into the format of its version
+func loadConfigFrom(v *viper.Viper, names []string) (config.Config, error) {
+	conf, err := decodeConfig(v, names)
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("MDBEXPORTER_MONGODB_URI") != "" {
+		os.Setenv("MDBEXPORTER_SERVER_0_MONGODB_URI", os.Getenv("MDBEXPORTER_MONGODB_URI"))
+	}
+
+	if uri != "" && uri != "mongodb://localhost:27017" {
+		os.Setenv("MDBEXPORTER_SERVER_0_MONGODB_URI", uri)
+	}
+
+	return conf, nil
+}
+
+// Decode the config into the format of its version, v4.0 configs are strictly checked using the files they are read from
+func decodeConfig(v *viper.Viper, names []string) (config.Config, error) {
 	var configVersion float32
-	err := viper.UnmarshalKey("version", &configVersion)
+	err := v.UnmarshalKey("version", &configVersion)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var conf config.Config
 	switch configVersion {
+	case 4.0:
+		if err := strictFiles(names); err != nil {
+			return nil, err
+		}
+
+		conf = &v4.Config{}
+
 	case 3.0:
 		conf = &v3.Config{}
 
 	case 2.0:
 		conf = &v2.Config{}
 
-	default:
+	case 1.0, 0:
 		conf = &v1.Config{}
+
+	default:
+		return nil, &config.ValidationError{
+			Path: "version",
+			Err:  fmt.Errorf("unsupported config version %.1f. Only [1.0, 2.0, 3.0, 4.0] are valid options", configVersion),
+		}
 	}
 
-	err = viper.Unmarshal(&conf)
+	err = v.Unmarshal(&conf)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	if os.Getenv("MDBEXPORTER_MONGODB_URI") != "" {
-		os.Setenv("MDBEXPORTER_SERVER_0_MONGODB_URI", os.Getenv("MDBEXPORTER_MONGODB_URI"))
+	return conf, nil
+}
+
+// Return the path to the web config file, either from the flag or written from the config.
+// The returned function removes a written file.
+func buildWebConfig(conf config.Config) (string, func(), error) {
+	path := webConfigFile
+	cleanup := func() {}
+
+	if path == "" && conf.GetWeb() != nil {
+		var err error
+		path, err = conf.GetWeb().WriteFile()
+		if err != nil {
+			return "", cleanup, err
+		}
+
+		cleanup = func() {
+			_ = os.Remove(path)
+		}
 	}
 
-	if uri != "" && uri != "mongodb://localhost:27017" {
-		os.Setenv("MDBEXPORTER_SERVER_0_MONGODB_URI", uri)
+	if path == "" {
+		return path, cleanup, nil
 	}
 
-	c, err := conf.Build()
-	return c, conf, err
+	return path, cleanup, web.Validate(path)
+}
+
+// Response of the config endpoint
+type configResponse struct {
+	Version   string                    `json:"version"`
+	Files     []string                  `json:"files"`
+	Config    map[string]interface{}    `json:"config"`
+	Effective collector.EffectiveConfig `json:"effective"`
 }
 
-// Run executes a blocking http server. Starts the http listener with the metrics and healthz endpoints.
-func buildHTTPServer(reg prometheus.Gatherer, conf config.Config) *http.Server {
+// Build the http handler with the metrics, probe, healthz, readyz and if enabled the config endpoints.
+// The files are the config files the collector was built from.
+func buildHandler(reg prometheus.Gatherer, c *collector.Collector, conf config.Config, files []string) http.Handler {
 	mux := http.NewServeMux()
 
 	if conf.GetMetricsPath() != "/" {
@@ -125,37 +334,213 @@ func buildHTTPServer(reg prometheus.Gatherer, conf config.Config) *http.Server {
 	}
 
 	mux.HandleFunc(config.HealthzPath, func(w http.ResponseWriter, r *http.Request) { http.Error(w, "OK", http.StatusOK) })
-	mux.HandleFunc(conf.GetMetricsPath(), func(w http.ResponseWriter, r *http.Request) {
-		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	mux.HandleFunc(config.ReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		status := c.Status()
+		ready, _ := status.Ready(readiness)
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(w).Encode(readyzResponse{
+			Ready:  ready,
+			Status: status,
+		})
+	})
+	if configEndpoint {
+		mux.HandleFunc(config.ConfigPath, configHandler(c, conf, files))
+	}
+
+	mux.HandleFunc(config.ProbePath, func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+
+		probe, release, err := c.Probe(ctx, target, collector.Filter{
+			Groups: r.URL.Query()["module"],
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		defer release()
+
+		probeReg := prometheus.NewRegistry()
+		probeReg.MustRegister(probe)
+		promhttp.HandlerFor(probeReg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 	})
 
-	srv := http.Server{Addr: conf.GetBindAddr(), Handler: mux}
-	return &srv
+	mux.HandleFunc(conf.GetMetricsPath(), metricsHandler(reg, c, collector.Filter{}))
+	for _, path := range conf.GetMetricsPaths() {
+		mux.HandleFunc(path.Path, metricsHandler(reg, c, collector.Filter{
+			Aggregations: path.Aggregations,
+			Groups:       path.Groups,
+		}))
+	}
+
+	return mux
+}
+
+// Serve the config after env expansion and defaulting with redacted credentials
+func configHandler(c *collector.Collector, conf config.Config, files []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		settings, err := config.Redact(conf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(configResponse{
+			Version:   configVersion(conf),
+			Files:     files,
+			Config:    settings,
+			Effective: c.EffectiveConfig(),
+		})
+	}
+}
+
+// The version of the config format
+func configVersion(conf config.Config) string {
+	switch conf.(type) {
+	case *v1.Config:
+		return "1.0"
+	case *v2.Config:
+		return "2.0"
+	case *v3.Config:
+		return "3.0"
+	case *v4.Config:
+		return "4.0"
+	}
+
+	return ""
+}
+
+// Serve the metrics of the aggregations matching the filter.
+// The aggregations may be restricted further using the aggregation and group query parameters.
+func metricsHandler(reg prometheus.Gatherer, c *collector.Collector, filter collector.Filter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+
+		// The collector is registered per scrape to bind it to the scrape deadline
+		scrapeReg := prometheus.NewRegistry()
+		scrapeReg.MustRegister(c.WithFilter(ctx, filter, collector.Filter{
+			Aggregations: r.URL.Query()["aggregation"],
+			Groups:       r.URL.Query()["group"],
+		}))
+
+		promhttp.HandlerFor(prometheus.Gatherers{reg, scrapeReg}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// Derive the scrape context from the request. If prometheus sends its scrape timeout the context
+// is cancelled shortly before prometheus would abort the scrape.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get(scrapeTimeoutHeader), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeOffset {
+		timeout -= scrapeOffset
+	}
+
+	return context.WithTimeout(r.Context(), timeout)
 }
 
 func initConfig() {
-	envPath := os.Getenv("MDBEXPORTER_CONFIG")
-
-	if configPath != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(configPath)
-	} else if envPath != "" {
-		// Use config file from env.
-		viper.SetConfigFile(envPath)
-	} else {
+	if _, err := readConfig(); err != nil {
+		panic(err)
+	}
+}
+
+// Read the config files and return their names.
+// Multiple files (or a directory or glob matching multiple files) are merged into a single config.
+func readConfig() (string, error) {
+	name, files, err := readConfigInto(viper.GetViper())
+	configFiles = files
+	return name, err
+}
+
+// Read the config files into the given viper and return their names.
+// The merged files are returned if the config is split across multiple files.
+func readConfigInto(v *viper.Viper) (string, *config.Files, error) {
+	v.SetConfigType("yaml")
+
+	paths := configSources()
+	if len(paths) == 0 {
 		// Find home directory.
 		usr, err := user.Current()
 		if err == nil {
-			viper.AddConfigPath(usr.HomeDir + "/.mongodb_query_exporter")
+			v.AddConfigPath(usr.HomeDir + "/.mongodb_query_exporter")
 		}
 
 		// System wide config
-		viper.AddConfigPath("/etc/mongodb-query-exporter")
-		viper.AddConfigPath("/etc/mongodb_query_exporter")
+		v.AddConfigPath("/etc/mongodb-query-exporter")
+		v.AddConfigPath("/etc/mongodb_query_exporter")
+
+		err = v.ReadInConfig()
+		return v.ConfigFileUsed(), nil, err
 	}
 
-	viper.SetConfigType("yaml")
-	if err := viper.ReadInConfig(); err != nil {
-		panic(err)
+	names, err := config.Expand(paths)
+	if err != nil {
+		return strings.Join(paths, ", "), nil, err
+	}
+
+	if len(names) == 1 {
+		v.SetConfigFile(names[0])
+		return names[0], nil, v.ReadInConfig()
 	}
+
+	files, err := config.Merge(names)
+	if err != nil {
+		return strings.Join(names, ", "), nil, err
+	}
+
+	b, err := yaml.Marshal(files.Settings)
+	if err != nil {
+		return strings.Join(names, ", "), nil, err
+	}
+
+	return strings.Join(names, ", "), files, v.ReadConfig(bytes.NewReader(b))
+}
+
+// The config files, directories or globs from the flags or from the env
+func configSources() []string {
+	if len(configPaths) > 0 {
+		return configPaths
+	}
+
+	if env := os.Getenv("MDBEXPORTER_CONFIG"); env != "" {
+		return filepath.SplitList(env)
+	}
+
+	return nil
+}
+
+// The names of the files the current config has been read from
+func configFileNames() []string {
+	return fileNames(viper.GetViper(), configFiles)
+}
+
+// The names of the merged files or of the single file read by the given viper
+func fileNames(v *viper.Viper, files *config.Files) []string {
+	if files != nil {
+		return files.Names
+	}
+
+	return []string{v.ConfigFileUsed()}
 }
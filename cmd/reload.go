@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+)
+
+// The reloader serves the http endpoints of the current collector and replaces the collector
+// once the configuration is reloaded
+type reloader struct {
+	reg       prometheus.Gatherer
+	collector *collector.Collector
+	conf      config.Config
+	handler   http.Handler
+	mutex     sync.RWMutex
+	reload    sync.Mutex
+	closed    bool
+}
+
+func newReloader(reg prometheus.Gatherer, c *collector.Collector, conf config.Config, files []string) *reloader {
+	return &reloader{
+		reg:       reg,
+		collector: c,
+		conf:      conf,
+		handler:   buildHandler(reg, c, conf, files),
+	}
+}
+
+// Serve the reload endpoint or delegate to the handler of the current collector
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == config.ReloadPath {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := r.Reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		http.Error(w, "OK", http.StatusOK)
+		return
+	}
+
+	r.mutex.RLock()
+	handler := r.handler
+	r.mutex.RUnlock()
+
+	handler.ServeHTTP(w, req)
+}
+
+// Reload on SIGHUP and if enabled as soon as the config file changes
+func (r *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := r.Reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to reload config: %s\n", err)
+			}
+		}
+	}()
+
+	if !watchConfig {
+		return
+	}
+
+	reload := func() {
+		if err := r.Reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to reload config: %s\n", err)
+		}
+	}
+
+	// Split configs are watched by directory to notice added and removed files
+	if configFiles != nil {
+		if err := watchConfigSources(configSources(), reload); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to watch config files: %s\n", err)
+		}
+
+		return
+	}
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		reload()
+	})
+
+	viper.WatchConfig()
+}
+
+// Read the config file and replace the current collector with a new one.
+// Connections of unchanged servers and cached metrics of unchanged aggregations are taken over.
+// If the new configuration is invalid the current collector is kept.
+func (r *reloader) Reload() error {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("the exporter is shutting down")
+	}
+
+	c, conf, files, err := r.build()
+	if err != nil {
+		config.ConfigLastReloadSuccessful.Set(0)
+		return err
+	}
+
+	r.mutex.RLock()
+	previous := r.collector
+	web := r.conf.GetWeb()
+	r.mutex.RUnlock()
+
+	// The http server is configured once at startup
+	if !reflect.DeepEqual(web, conf.GetWeb()) {
+		fmt.Fprintf(os.Stderr, "the web config (tls and basic auth) has changed, it is only applied after a restart\n")
+	}
+
+	c.Adopt(previous)
+	startTasks(c)
+
+	r.mutex.Lock()
+	r.collector = c
+	r.conf = conf
+	r.handler = buildHandler(r.reg, c, conf, files)
+	promCollector = c
+	r.mutex.Unlock()
+
+	if err := previous.Shutdown(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to shutdown previous collector: %s\n", err)
+	}
+
+	removeStaleSeries(previous, c)
+
+	config.ConfigLastReloadSuccessful.Set(1)
+	config.ConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+	return nil
+}
+
+// Build a collector from the current config files and return the names of the files it was built from.
+// The config is read into a fresh viper so a rejected config leaves the global config state untouched.
+func (r *reloader) build() (*collector.Collector, config.Config, []string, error) {
+	v := viper.New()
+	bindConfig(v)
+
+	_, files, err := readConfigInto(v)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	names := fileNames(v, files)
+	c, conf, err := buildCollectorFrom(v, names)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if conf.GetBindAddr() != r.conf.GetBindAddr() {
+		_ = c.Shutdown(context.Background())
+		return nil, nil, nil, fmt.Errorf("the bind address can not be changed without a restart")
+	}
+
+	return c, conf, names, nil
+}
+
+// Remove the query counter and server up series of servers and aggregations which are not part of the current collector.
+// The series of the remaining ones are kept so their counters stay monotonic across reloads.
+func removeStaleSeries(previous, current *collector.Collector) {
+	before, after := previous.EffectiveConfig(), current.EffectiveConfig()
+
+	servers := make(map[string]bool)
+	for _, name := range after.Servers {
+		servers[name] = true
+	}
+
+	for _, name := range before.Servers {
+		if !servers[name] {
+			config.Counter.DeletePartialMatch(prometheus.Labels{"server": name})
+			config.ServerUp.DeletePartialMatch(prometheus.Labels{"server": name})
+		}
+	}
+
+	aggregations := make(map[string]bool)
+	for _, aggregation := range after.Aggregations {
+		aggregations[aggregation.Name] = true
+	}
+
+	for _, aggregation := range before.Aggregations {
+		if !aggregations[aggregation.Name] {
+			config.Counter.DeletePartialMatch(prometheus.Labels{"aggregation": aggregation.Name})
+		}
+	}
+}
+
+// Shutdown the current collector, no further reloads are accepted
+func (r *reloader) Shutdown(ctx context.Context) error {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+
+	r.closed = true
+
+	r.mutex.RLock()
+	c := r.collector
+	r.mutex.RUnlock()
+
+	return c.Shutdown(ctx)
+}
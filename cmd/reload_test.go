@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"github.com/spf13/viper"
+	"github.com/tj/assert"
+)
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	}
+
+	configPaths = []string{file}
+	defer func() {
+		configPaths = nil
+	}()
+
+	writeConfig(`
+version: 3.0
+servers:
+- name: main
+aggregations:
+- pipeline: "[]"
+`)
+
+	_, err := readConfig()
+	assert.NoError(t, err)
+
+	c, conf, err := buildCollector()
+	assert.NoError(t, err)
+
+	r := newReloader(prometheus.NewRegistry(), c, conf, configFileNames())
+	defer func() {
+		_ = r.Shutdown(context.Background())
+	}()
+
+	config.Counter.Reset()
+	config.Counter.WithLabelValues("aggregation_0", "main", "SUCCESS").Inc()
+	config.ServerUp.WithLabelValues("main").Set(1)
+
+	t.Run("Rejected config keeps the metrics and the global config", func(t *testing.T) {
+		writeConfig("version: 9.0\n")
+
+		assert.Error(t, r.Reload())
+		assert.Equal(t, 1.0, testutil.ToFloat64(config.Counter.WithLabelValues("aggregation_0", "main", "SUCCESS")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(config.ServerUp.WithLabelValues("main")))
+		assert.Equal(t, "3", viper.GetString("version"))
+		assert.Same(t, c, r.collector)
+	})
+
+	t.Run("Series of removed servers are removed once the config is reloaded", func(t *testing.T) {
+		writeConfig(`
+version: 3.0
+servers:
+- name: other
+aggregations:
+- pipeline: "[]"
+`)
+
+		assert.NoError(t, r.Reload())
+		assert.Equal(t, 0, config.Counter.DeletePartialMatch(prometheus.Labels{"server": "main"}))
+		assert.Equal(t, 0, config.ServerUp.DeletePartialMatch(prometheus.Labels{"server": "main"}))
+	})
+
+	t.Run("Config endpoint reports the files the collector was built from", func(t *testing.T) {
+		configEndpoint = true
+		defer func() {
+			configEndpoint = false
+		}()
+
+		assert.NoError(t, r.Reload())
+
+		// Reading another config changes the global config state but not the served collector
+		other := filepath.Join(t.TempDir(), "other.yaml")
+		assert.NoError(t, os.WriteFile(other, []byte("version: 3.0\n"), 0600))
+		configPaths = []string{other}
+		_, err := readConfig()
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, config.ConfigPath, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var response configResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, []string{file}, response.Files)
+	})
+}
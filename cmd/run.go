@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	flag "github.com/spf13/pflag"
+)
+
+// A fixed set of metrics exposed without descriptors
+type metricsCollector []prometheus.Metric
+
+func (m metricsCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (m metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range m {
+		ch <- metric
+	}
+}
+
+// Execute the aggregations once and print the metrics as well as a summary per aggregation
+func dryRunCommand(args []string) int {
+	var (
+		filter  collector.Filter
+		timeout time.Duration
+	)
+
+	flags := flag.NewFlagSet("dry-run", flag.ContinueOnError)
+	flags.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, may be repeated (default is $HOME/.mongodb_query_exporter/config.yaml)")
+	flags.StringSliceVar(&filter.Aggregations, "aggregation", nil, "Only run the aggregations with the given names")
+	flags.StringSliceVar(&filter.Groups, "group", nil, "Only run the aggregations of the given groups")
+	flags.DurationVar(&timeout, "timeout", time.Minute, "Time to wait for server connections and all aggregations")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, err := readConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	c, _, err := buildCollector()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_ = c.StartServerMonitor()
+	defer func() {
+		_ = c.Shutdown(context.Background())
+	}()
+
+	return dryRun(ctx, c, filter, os.Stdout, os.Stderr)
+}
+
+func dryRun(ctx context.Context, c *collector.Collector, filter collector.Filter, stdout, stderr io.Writer) int {
+	results := c.Run(ctx, filter)
+	if len(results) == 0 {
+		fmt.Fprintln(stderr, "no aggregations matched")
+		return 1
+	}
+
+	code := 0
+
+	var metrics metricsCollector
+	w := tabwriter.NewWriter(stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AGGREGATION\tSERVER\tNAMESPACE\tROWS\tMETRICS\tDURATION\tERROR")
+
+	for _, result := range results {
+		errMsg := ""
+		if result.Err != nil {
+			errMsg = result.Err.Error()
+			code = 1
+		}
+
+		ns := "-"
+		if result.Database != "" || result.Collection != "" {
+			ns = result.Database + "." + result.Collection
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			result.Aggregation, result.Server, ns, result.Rows, len(result.Metrics),
+			result.Duration.Round(time.Millisecond), errMsg)
+
+		metrics = append(metrics, result.Metrics...)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metrics)
+	families, err := reg.Gather()
+	if err != nil {
+		fmt.Fprintf(w, "\n%s\n", err)
+		code = 1
+	}
+
+	_ = w.Flush()
+
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(stdout, family); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	return code
+}
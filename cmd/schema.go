@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	v4 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v4"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Print the JSON Schema of the v4.0 config format
+func schemaCommand(args []string) int {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	b, err := v4.SchemaJSON()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	_, _ = os.Stdout.Write(b)
+	return 0
+}
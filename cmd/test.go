@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// A file with tests for an exporter config
+type testFile struct {
+	// Path to the exporter config, relative to the test file
+	Config string     `yaml:"config"`
+	Tests  []testCase `yaml:"tests"`
+}
+
+// Fixtures and the metrics expected from them
+type testCase struct {
+	Name     string    `yaml:"name"`
+	Fixtures []fixture `yaml:"fixtures"`
+	// Expected samples in the exposition format, HELP and TYPE lines are optional
+	Expected string `yaml:"expected"`
+}
+
+// Documents returned by an aggregation or by all aggregations on a collection
+type fixture struct {
+	Aggregation string                   `yaml:"aggregation"`
+	Server      string                   `yaml:"server"`
+	Database    string                   `yaml:"database"`
+	Collection  string                   `yaml:"collection"`
+	Documents   []map[string]interface{} `yaml:"documents"`
+}
+
+// Run config tests using fixtures instead of MongoDB
+func testCommand(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, overrides the config referenced by the test files")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "no test files given")
+		return 2
+	}
+
+	code := 0
+	override := configPaths
+	for _, path := range flags.Args() {
+		if !runTestFile(path, override, os.Stdout) {
+			code = 1
+		}
+	}
+
+	return code
+}
+
+// Run all tests of a test file and report whether they passed
+func runTestFile(path string, override []string, w io.Writer) bool {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL\t%s: %s\n", path, err)
+		return false
+	}
+
+	var file testFile
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		fmt.Fprintf(w, "FAIL\t%s: %s\n", path, err)
+		return false
+	}
+
+	configPaths = override
+	if len(configPaths) == 0 && file.Config != "" {
+		conf := file.Config
+		if !filepath.IsAbs(conf) {
+			conf = filepath.Join(filepath.Dir(path), conf)
+		}
+
+		configPaths = []string{conf}
+	}
+
+	if _, err := readConfig(); err != nil {
+		fmt.Fprintf(w, "FAIL\t%s: %s\n", path, err)
+		return false
+	}
+
+	passed := true
+	for i, test := range file.Tests {
+		name := test.Name
+		if name == "" {
+			name = fmt.Sprintf("tests[%d]", i)
+		}
+
+		failures := runTest(test)
+		if len(failures) == 0 {
+			fmt.Fprintf(w, "ok\t%s: %s\n", path, name)
+			continue
+		}
+
+		passed = false
+		fmt.Fprintf(w, "FAIL\t%s: %s\n", path, name)
+		for _, failure := range failures {
+			fmt.Fprintf(w, "\t%s\n", failure)
+		}
+	}
+
+	return passed
+}
+
+// Run a test case and return the failures
+func runTest(test testCase) []string {
+	c, _, err := buildCollector()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	drivers := make(map[string]*collector.FixtureDriver)
+	for _, srv := range c.Status().Servers {
+		drivers[srv.Name] = collector.NewFixtureDriver()
+		if err := c.UseDriver(srv.Name, drivers[srv.Name]); err != nil {
+			return []string{err.Error()}
+		}
+	}
+
+	for i, fixture := range test.Fixtures {
+		if err := addFixture(c, drivers, fixture); err != nil {
+			return []string{fmt.Sprintf("fixtures[%d]: %s", i, err)}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var failures []string
+	var metrics metricsCollector
+	for _, result := range c.Run(ctx) {
+		metrics = append(metrics, result.Metrics...)
+		if result.Err != nil && !errors.Is(result.Err, collector.ErrNoFixture) {
+			failures = append(failures, fmt.Sprintf("aggregation %s on server %s failed: %s", result.Aggregation, result.Server, result.Err))
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metrics)
+	families, err := reg.Gather()
+	if err != nil {
+		return append(failures, err.Error())
+	}
+
+	expected, err := new(expfmt.TextParser).TextToMetricFamilies(strings.NewReader(test.Expected + "\n"))
+	if err != nil {
+		return append(failures, fmt.Sprintf("invalid expected metrics: %s", err))
+	}
+
+	var expectedFamilies []*dto.MetricFamily
+	for _, family := range expected {
+		expectedFamilies = append(expectedFamilies, family)
+	}
+
+	return append(failures, diffSamples(samples(expectedFamilies), samples(families))...)
+}
+
+// Add the fixture documents to the drivers of the fixture servers
+func addFixture(c *collector.Collector, drivers map[string]*collector.FixtureDriver, fixture fixture) error {
+	var aggregation *collector.Aggregation
+	if fixture.Aggregation != "" {
+		var err error
+		aggregation, err = c.GetAggregation(fixture.Aggregation)
+		if err != nil {
+			return err
+		}
+	} else if fixture.Database == "" {
+		return fmt.Errorf("either aggregation or database and collection are required")
+	}
+
+	if _, ok := drivers[fixture.Server]; fixture.Server != "" && !ok {
+		return fmt.Errorf("server %s not found", fixture.Server)
+	}
+
+	for name, driver := range drivers {
+		if fixture.Server != "" && fixture.Server != name {
+			continue
+		}
+
+		if err := driver.AddResult(aggregation, fixture.Database, fixture.Collection, fixture.Documents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Render the samples in a canonical form (sorted labels) independent of the metric type
+func samples(families []*dto.MetricFamily) []string {
+	var lines []string
+	for _, family := range families {
+		for _, m := range family.Metric {
+			var labels []string
+			for _, label := range m.Label {
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+
+			sort.Strings(labels)
+
+			var value float64
+			switch {
+			case m.Gauge != nil:
+				value = m.Gauge.GetValue()
+			case m.Counter != nil:
+				value = m.Counter.GetValue()
+			case m.Untyped != nil:
+				value = m.Untyped.GetValue()
+			}
+
+			lines = append(lines, fmt.Sprintf("%s{%s} %s", family.GetName(), strings.Join(labels, ","), strconv.FormatFloat(value, 'g', -1, 64)))
+		}
+	}
+
+	sort.Strings(lines)
+	return lines
+}
+
+// Report expected samples which are missing and samples which have not been expected
+func diffSamples(expected, actual []string) []string {
+	var diff []string
+	for _, line := range expected {
+		if !containsLine(actual, line) {
+			diff = append(diff, "missing:    "+line)
+		}
+	}
+
+	for _, line := range actual {
+		if !containsLine(expected, line) {
+			diff = append(diff, "unexpected: "+line)
+		}
+	}
+
+	return diff
+}
+
+func containsLine(lines []string, line string) bool {
+	i := sort.SearchStrings(lines, line)
+	return i < len(lines) && lines[i] == line
+}
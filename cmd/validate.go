@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Validate a config file without connecting to any server
+func validateCommand(args []string) int {
+	var opts config.ValidateOptions
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.StringArrayVarP(&configPaths, "file", "f", nil, "config file, directory or glob, may be repeated (default is $HOME/.mongodb_query_exporter/config.yaml)")
+	flags.BoolVar(&opts.CheckFiles, "check-files", false, "Read the secret and tls files referenced by the config")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	return validate(os.Stdout, os.Stderr, opts)
+}
+
+func validate(stdout, stderr io.Writer, opts config.ValidateOptions) int {
+	name, err := readConfig()
+	if err == nil {
+		var conf config.Config
+		conf, err = loadConfig()
+		if err == nil {
+			err = conf.Validate(opts)
+		}
+	}
+
+	if err == nil {
+		fmt.Fprintf(stdout, "%s is valid\n", name)
+		return 0
+	}
+
+	var errs config.ValidationErrors
+	var validationErr *config.ValidationError
+	switch {
+	case errors.As(err, &errs):
+	case errors.As(err, &validationErr):
+		errs = config.ValidationErrors{validationErr}
+	default:
+		fmt.Fprintf(stderr, "%s: %s\n", name, err)
+		return 1
+	}
+
+	// Errors of split configs are reported with the file they are defined in
+	for _, err := range errs {
+		locate(err)
+		if err.File != "" {
+			fmt.Fprintln(stderr, err)
+		} else {
+			fmt.Fprintf(stderr, "%s: %s\n", name, err)
+		}
+	}
+
+	return 1
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // A collector is a metric collector group for one single MongoDB server.
@@ -21,8 +23,23 @@ type Collector struct {
 	config       *Config
 	aggregations []*Aggregation
 	counter      *prometheus.CounterVec
-	cache        map[string]*cacheEntry
+	serverUp     *prometheus.GaugeVec
+	connector    TargetConnector
+	cache        map[cacheKey]*cacheEntry
+	namespaces   map[namespaceKey]*namespaceEntry
+	watchers     []*watcher
 	mutex        *sync.Mutex
+	tasks        sync.WaitGroup
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// Cached metrics belong to a registered aggregation executed on a server and namespace.
+// Aggregations are identified by themselves, aggregations with the same pipeline may still differ in their metrics.
+type cacheKey struct {
+	aggregation *Aggregation
+	server      string
+	ns          namespace
 }
 
 // A cached metric consists of the metric and a ttl in seconds
@@ -31,12 +48,6 @@ type cacheEntry struct {
 	ttl int64
 }
 
-// A server needs a driver (implementation) and a unique name
-type server struct {
-	name   string
-	driver Driver
-}
-
 type option func(c *Collector)
 
 // Collector configuration with default metric configurations
@@ -46,18 +57,39 @@ type Config struct {
 	DefaultMode       string
 	DefaultDatabase   string
 	DefaultCollection string
+	PingInterval      time.Duration
 }
 
-// Aggregation defines what aggregation pipeline is executed on what servers
+// Aggregation defines what aggregation pipeline (or database command) is executed on what servers
 type Aggregation struct {
-	Servers    []string
-	Cache      time.Duration
-	Mode       string
-	Database   string
-	Collection string
-	Pipeline   string
-	Metrics    []*Metric
-	pipeline   bson.A
+	Name              string
+	Servers           []string
+	Cache             *time.Duration // nil applies the default cache in pull mode, 0 disables the cache and -1 caches forever
+	Mode              string
+	Kind              string
+	Database          string
+	Collection        string
+	DatabasePattern   string
+	CollectionPattern string
+	DiscoveryInterval time.Duration
+	Pipeline          string
+	Command           string
+	ResultPath        string
+	Timeout           time.Duration
+	ReadPreference    string
+	ReadConcern       string
+	AllowDiskUse      bool
+	Hint              string
+	Collation         *options.Collation
+	BatchSize         int32
+	Comment           string
+	Group             string
+	Metrics           []*Metric
+	pipeline          bson.A
+	command           bson.D
+	queryOptions      *QueryOptions
+	matchDatabase     matcher
+	matchCollection   matcher
 }
 
 // A metric defines how a certain value is exported from a MongoDB aggregation
@@ -80,6 +112,10 @@ var (
 	ErrValueNotFound = errors.New("value not found in result set")
 	//No cached metric available
 	ErrNotCached = errors.New("metric not available from cache")
+	//The driver has not been connected yet
+	ErrNotConnected = errors.New("not connected to server")
+	//The query did not finish within its time limit
+	ErrQueryTimeout = errors.New("query exceeded its time limit")
 )
 
 const (
@@ -89,12 +125,31 @@ const (
 	ModePull = "pull"
 	//Push mode (Uses changestream which is only supported with MongoDB >= 3.6)
 	ModePush = "push"
+	//Run an aggregation pipeline on a collection (default)
+	KindAggregate = "aggregate"
+	//Run a database command, the result document is used as aggregation result
+	KindCommand = "command"
+	//Commands are executed on the admin database if no database is set
+	DefaultCommandDatabase = "admin"
+	//Interval in which databases and collections matching a pattern are discovered
+	DefaultDiscoveryInterval = 5 * time.Minute
 	//Metric generated successfully
 	ResultSuccess = "SUCCESS"
 	//Metric value could not been determined
 	ResultError = "ERROR"
+	//Operation exceeded its time limit on the server (maxTimeMS)
+	ResultTimeout = "TIMEOUT"
+	//Aggregation did not finish before the scrape deadline
+	ResultScrapeTimeout = "SCRAPE_TIMEOUT"
 )
 
+// MongoDB server error code MaxTimeMSExpired
+const errCodeMaxTimeMSExpired = 50
+
+// The client side deadline of a query exceeds its maxTimeMS by this grace period so the server aborts the operation
+// and reports the timeout before the client gives up waiting
+const maxTimeGrace = time.Second
+
 // Create a new collector
 func New(opts ...option) *Collector {
 	c := &Collector{
@@ -104,8 +159,10 @@ func New(opts ...option) *Collector {
 		},
 	}
 
-	c.cache = make(map[string]*cacheEntry)
+	c.cache = make(map[cacheKey]*cacheEntry)
+	c.namespaces = make(map[namespaceKey]*namespaceEntry)
 	c.mutex = &sync.Mutex{}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	for _, opt := range opts {
 		opt(c)
@@ -121,6 +178,13 @@ func WithCounter(m *prometheus.CounterVec) option {
 	}
 }
 
+// Pass a gauge which reports whether a server is up
+func WithServerUp(m *prometheus.GaugeVec) option {
+	return func(c *Collector) {
+		c.serverUp = m
+	}
+}
+
 // Pass a logger to the collector
 func WithLogger(l Logger) option {
 	return func(c *Collector) {
@@ -135,8 +199,9 @@ func WithConfig(conf *Config) option {
 	}
 }
 
-// Run metric c for each metric either in push or pull mode
-func (c *Collector) RegisterServer(name string, driver Driver) error {
+// Register a server on which aggregations are executed.
+// The server is considered up unless it is connected lazily using WithConnect.
+func (c *Collector) RegisterServer(name string, driver Driver, opts ...ServerOption) error {
 	for _, srv := range c.servers {
 		if srv.name == name {
 			return fmt.Errorf("server %s is already registered", name)
@@ -146,6 +211,16 @@ func (c *Collector) RegisterServer(name string, driver Driver) error {
 	srv := &server{
 		name:   name,
 		driver: driver,
+		ready:  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	if srv.connect == nil {
+		srv.up = true
+		close(srv.ready)
 	}
 
 	c.servers = append(c.servers, srv)
@@ -154,34 +229,71 @@ func (c *Collector) RegisterServer(name string, driver Driver) error {
 
 // Run metric c for each metric either in push or pull mode
 func (c *Collector) RegisterAggregation(aggregation *Aggregation) error {
-	if len(aggregation.Servers) != 0 && len(aggregation.Servers) != len(c.GetServers(aggregation.Servers)) {
-		return fmt.Errorf("aggregation bound to server which have not been found")
-	}
+	c.applyDefaults(aggregation)
 
-	err := bson.UnmarshalExtJSON([]byte(aggregation.Pipeline), false, &aggregation.pipeline)
-	if err != nil {
-		return errors.Wrap(err, "failed to decode json aggregation pipeline")
-	}
-
-	if c.config.DefaultCache > 0 && aggregation.Cache != 0 {
-		aggregation.Cache = c.config.DefaultCache
+	if err := c.parseAggregation(aggregation); err != nil {
+		return err
 	}
 
 	for _, metric := range aggregation.Metrics {
 		c.logger.Debugf("register metric %s", metric.Name)
-		metric.desc = c.describeMetric(metric)
+		metric.desc = c.describeMetric(aggregation, metric)
 	}
 
 	c.aggregations = append(c.aggregations, aggregation)
 	return nil
 }
 
+// Apply the global defaults to the settings which are not set by the aggregation
+func (c *Collector) applyDefaults(aggregation *Aggregation) {
+	if aggregation.Kind == "" {
+		aggregation.Kind = KindAggregate
+	}
+
+	if aggregation.Mode == "" {
+		aggregation.Mode = c.config.DefaultMode
+	}
+
+	if aggregation.Mode == "" {
+		aggregation.Mode = ModePull
+	}
+
+	// Patterns take precedence over the default namespace
+	switch {
+	case aggregation.DatabasePattern != "" || aggregation.Database != "":
+	case aggregation.Kind == KindCommand:
+		aggregation.Database = DefaultCommandDatabase
+	default:
+		aggregation.Database = c.config.DefaultDatabase
+	}
+
+	if aggregation.Kind == KindAggregate && aggregation.Collection == "" && aggregation.CollectionPattern == "" {
+		aggregation.Collection = c.config.DefaultCollection
+	}
+
+	// Aggregations in push mode are cached until the next change unless they have a cache,
+	// an explicit cache of 0 opts out of the default cache
+	if aggregation.Mode == ModePull && aggregation.Cache == nil {
+		cache := c.config.DefaultCache
+		aggregation.Cache = &cache
+	}
+}
+
+// The time the results of the aggregation are cached, see Aggregation.Cache
+func (aggregation *Aggregation) cache() time.Duration {
+	if aggregation.Cache == nil {
+		return 0
+	}
+
+	return *aggregation.Cache
+}
+
 // Create prometheus descriptor
-func (c *Collector) describeMetric(metric *Metric) *prometheus.Desc {
+func (c *Collector) describeMetric(aggregation *Aggregation, metric *Metric) *prometheus.Desc {
 	return prometheus.NewDesc(
 		metric.Name,
 		metric.Help,
-		append([]string{"server"}, metric.Labels...),
+		append(aggregation.labelNames(), metric.Labels...),
 		metric.ConstLabels,
 	)
 }
@@ -207,12 +319,53 @@ func (c *Collector) GetServers(names []string) []*server {
 	return servers
 }
 
+// Return a registered aggregation by its name (or aggregation_%d if it has no name)
+func (c *Collector) GetAggregation(name string) (*Aggregation, error) {
+	for i, aggregation := range c.aggregations {
+		if aggregation.label(i) == name {
+			return aggregation, nil
+		}
+	}
+
+	return nil, fmt.Errorf("aggregation %s not found", name)
+}
+
+// Replace the driver of a registered server which is considered connected afterwards.
+// Must be called before the background tasks are started.
+func (c *Collector) UseDriver(name string, driver Driver) error {
+	servers := c.GetServers([]string{name})
+	if len(servers) == 0 {
+		return fmt.Errorf("server %s not found", name)
+	}
+
+	srv := servers[0]
+	srv.driver = driver
+	srv.connect = nil
+	srv.watch = nil
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.up = true
+
+	select {
+	case <-srv.ready:
+	default:
+		close(srv.ready)
+	}
+
+	return nil
+}
+
 // Describe is implemented with DescribeByCollect
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	if c.counter != nil {
 		c.counter.Describe(ch)
 	}
 
+	if c.serverUp != nil {
+		c.serverUp.Describe(ch)
+	}
+
 	for _, aggregation := range c.aggregations {
 		for _, metric := range aggregation.Metrics {
 			ch <- metric.desc
@@ -222,66 +375,238 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect all metrics from queries
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(context.Background(), ch, scope{})
+}
+
+// Returns a collector which stops waiting for aggregations as soon as the given context is done.
+// Metrics from aggregations which finished in time are still collected while the others are counted
+// with the result SCRAPE_TIMEOUT.
+func (c *Collector) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{c, ctx, scope{}}
+}
+
+// A collector bound to a context
+type contextCollector struct {
+	*Collector
+	ctx   context.Context
+	scope scope
+}
+
+// Collect all metrics from queries until the context is done
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.ctx, ch, c.scope)
+}
+
+// An aggregation executed on a server and namespace
+type job struct {
+	id          int
+	i           int
+	aggregation *Aggregation
+	srv         *server
+	ns          namespace
+}
+
+// The namespaces of an aggregation on a server discovered during a scrape
+type discovery struct {
+	i           int
+	aggregation *Aggregation
+	srv         *server
+	namespaces  []namespace
+	err         error
+	// Set by the collecting goroutine once the result has been received
+	done bool
+}
+
+// The metrics generated by a job
+type jobResult struct {
+	job     job
+	metrics []prometheus.Metric
+	err     error
+}
+
+func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric, s scope) {
 	c.logger.Debugf("start collecting metrics")
-	var wg sync.WaitGroup
+	var discoveries []*discovery
 
 	for i, aggregation := range c.aggregations {
-		for _, srv := range c.GetServers(aggregation.Servers) {
-			metrics, err := c.getCached(aggregation, srv)
+		if !s.matchAggregation(aggregation, i) {
+			continue
+		}
+
+		for _, srv := range c.scopeServers(aggregation, s) {
+			if !srv.isUp() {
+				c.logger.Debugf("skip %s, server %s is down", aggregation.label(i), srv.name)
+				continue
+			}
+
+			discoveries = append(discoveries, &discovery{i: i, aggregation: aggregation, srv: srv})
+		}
+	}
+
+	// Namespaces are discovered concurrently, the discovery of many databases on one server must not use up the scrape deadline of the others
+	discovered := make(chan *discovery, len(discoveries))
+	for _, d := range discoveries {
+		go func(d *discovery) {
+			d.namespaces, d.err = c.resolveNamespaces(ctx, d.aggregation, d.srv)
+			discovered <- d
+		}(d)
+	}
 
-			if err == nil {
-				c.logger.Debugf("use value from cache for %s", aggregation.Pipeline)
+	// Aggregations are started as soon as their namespaces are discovered.
+	// Aggregations which finish after the context is done drop their result.
+	var jobs []job
+	var done []bool
+	results := make(chan jobResult)
+	run := func(j job) {
+		metrics, err := c.aggregate(ctx, j.aggregation, j.srv, j.ns)
+		select {
+		case results <- jobResult{j, metrics, err}:
+		case <-ctx.Done():
+		}
+	}
 
-				for _, m := range metrics {
-					ch <- m
+wait:
+	for pending, running := len(discoveries), 0; pending > 0 || running > 0; {
+		select {
+		case d := <-discovered:
+			pending--
+			d.done = true
+
+			if d.err != nil {
+				// The aggregation could not be run in time, not because the discovery failed
+				if ctx.Err() != nil {
+					c.count(d.i, d.srv, ResultScrapeTimeout)
+					continue
 				}
+
+				c.logger.Errorf("failed to discover namespaces", "err", d.err, "name", d.srv.name)
+				c.count(d.i, d.srv, resultOf(d.err))
 				continue
 			}
 
-			wg.Add(1)
-			go func(i int, aggregation *Aggregation, srv *server, ch chan<- prometheus.Metric) {
-				defer wg.Done()
-				err := c.aggregate(aggregation, srv, ch)
+			for _, ns := range d.namespaces {
+				metrics, err := c.getCached(d.aggregation, d.srv, ns)
 
-				if c.counter == nil {
-					return
+				if err == nil {
+					c.logger.Debugf("use value from cache for %s", d.aggregation.Pipeline)
+
+					for _, m := range metrics {
+						ch <- m
+					}
+					continue
 				}
 
-				var result string
-				if err == nil {
-					result = ResultSuccess
-				} else {
-					c.logger.Errorf("failed to generate metric", "err", err, "name", srv.name)
+				j := job{len(jobs), d.i, d.aggregation, d.srv, ns}
+				jobs = append(jobs, j)
+				done = append(done, false)
+				running++
+				go run(j)
+			}
+		case r := <-results:
+			running--
+			done[r.job.id] = true
+			if r.err != nil {
+				c.logger.Errorf("failed to generate metric", "err", r.err, "name", r.job.srv.name)
+			}
 
-					result = ResultError
+			for _, m := range r.metrics {
+				ch <- m
+			}
+
+			c.count(r.job.i, r.job.srv, resultOf(r.err))
+		case <-ctx.Done():
+			c.logger.Warnf("scrape deadline exceeded, skip %d pending aggregations and %d pending namespace discoveries", running, pending)
+
+			for _, d := range discoveries {
+				if !d.done {
+					c.count(d.i, d.srv, ResultScrapeTimeout)
+				}
+			}
+
+			for _, j := range jobs {
+				if !done[j.id] {
+					c.count(j.i, j.srv, ResultScrapeTimeout)
 				}
+			}
 
-				c.counter.With(prometheus.Labels{
-					"server":      srv.name,
-					"aggregation": fmt.Sprintf("aggregation_%d", i),
-					"result":      result,
-				}).Inc()
-			}(i, aggregation, srv, ch)
+			break wait
 		}
 	}
 
-	wg.Wait()
+	if s.target != nil {
+		return
+	}
 
 	if c.counter != nil {
 		c.counter.Collect(ch)
 	}
+
+	if c.serverUp != nil {
+		c.serverUp.Collect(ch)
+	}
 }
 
-func (c *Collector) updateCache(aggregation *Aggregation, srv *server, m []prometheus.Metric) {
+// Map an aggregation error to a query counter result
+func resultOf(err error) string {
+	switch {
+	case err == nil:
+		return ResultSuccess
+	case isServerTimeout(err) || errors.Is(err, ErrQueryTimeout):
+		return ResultTimeout
+	default:
+		return ResultError
+	}
+}
+
+// Increase the query counter for an aggregation executed on a server
+func (c *Collector) count(i int, srv *server, result string) {
+	if c.counter == nil || srv.adhoc {
+		return
+	}
+
+	c.counter.With(prometheus.Labels{
+		"server":      srv.name,
+		"aggregation": c.aggregations[i].label(i),
+		"result":      result,
+	}).Inc()
+}
+
+// Effective timeout of an aggregation, either the aggregation specific one or the global query timeout
+func (c *Collector) timeout(aggregation *Aggregation) time.Duration {
+	if aggregation.Timeout > 0 {
+		return aggregation.Timeout
+	}
+
+	return c.config.QueryTimeout
+}
+
+// Reports whether the operation was aborted on the server because it exceeded maxTimeMS
+func isServerTimeout(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(errCodeMaxTimeMSExpired)
+}
+
+func (c *Collector) updateCache(aggregation *Aggregation, srv *server, ns namespace, m []prometheus.Metric) {
+	// No watcher invalidates the results of an ad-hoc target
+	if srv.adhoc {
+		return
+	}
+
 	var ttl int64
 
-	if (aggregation.Mode == ModePush && aggregation.Cache == 0) || aggregation.Cache == -1 {
+	cache := aggregation.cache()
+	if aggregation.Mode == ModePush && cache == 0 && !c.isWatched(aggregation, srv) {
+		c.logger.Debugf("skip caching metrics from aggregation %s, no changestream is watched", aggregation.Pipeline)
+		return
+	}
+
+	if (aggregation.Mode == ModePush && cache == 0) || cache == -1 {
 		c.logger.Debugf("cache metrics from aggregation %s until new push", aggregation.Pipeline)
 		ttl = -1
 
-	} else if aggregation.Cache > 0 {
-		c.logger.Debugf("cache metris from aggregation %s for %d", aggregation.Pipeline, aggregation.Cache)
-		ttl = time.Now().Unix() + int64(aggregation.Cache.Seconds())
+	} else if cache > 0 {
+		c.logger.Debugf("cache metris from aggregation %s for %d", aggregation.Pipeline, cache)
+		ttl = time.Now().Unix() + int64(cache.Seconds())
 	} else {
 		c.logger.Debugf("skip caching metrics from aggregation %s", aggregation.Pipeline)
 		return
@@ -290,20 +615,25 @@ func (c *Collector) updateCache(aggregation *Aggregation, srv *server, m []prome
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.cache[aggregation.Pipeline+srv.name] = &cacheEntry{m, ttl}
+	c.cache[cacheKey{aggregation, srv.name, ns}] = &cacheEntry{m, ttl}
 }
 
-func (c *Collector) getCached(aggregation *Aggregation, srv *server) ([]prometheus.Metric, error) {
+func (c *Collector) getCached(aggregation *Aggregation, srv *server, ns namespace) ([]prometheus.Metric, error) {
+	if srv.adhoc {
+		return nil, ErrNotCached
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if e, exists := c.cache[aggregation.Pipeline+srv.name]; exists {
+	key := cacheKey{aggregation, srv.name, ns}
+	if e, exists := c.cache[key]; exists {
 		if e.ttl == -1 || e.ttl >= time.Now().Unix() {
 			return e.m, nil
 		}
 
 		// entry can be removed from cache since its expired
-		delete(c.cache, aggregation.Pipeline+srv.name)
+		delete(c.cache, key)
 	}
 
 	return nil, ErrNotCached
@@ -312,19 +642,62 @@ func (c *Collector) getCached(aggregation *Aggregation, srv *server) ([]promethe
 // Start MongoDB watchers for metrics where push is enabled.
 // As soon as a new event is registered the cache gets invalidated and the aggregation
 // will be re evaluated during the next scrape.
+// Watchers which fail are restarted with an exponential backoff, meanwhile the aggregation is pulled.
 // This is a non blocking operation.
 func (c *Collector) StartCacheInvalidator() error {
-	for _, aggregation := range c.aggregations {
+	for i, aggregation := range c.aggregations {
 		if aggregation.Mode != ModePush {
 			continue
 		}
 
 		for _, srv := range c.GetServers(aggregation.Servers) {
+			w := &watcher{
+				source:      aggregation,
+				aggregation: aggregation.label(i),
+				server:      srv.name,
+			}
+
+			c.mutex.Lock()
+			c.watchers = append(c.watchers, w)
+			c.mutex.Unlock()
+
+			c.tasks.Add(1)
 			go func(aggregation *Aggregation, srv *server) {
-				err := c.pushUpdate(aggregation, srv)
+				defer c.tasks.Done()
+
+				// Wait until the server is connected
+				select {
+				case <-srv.ready:
+				case <-c.ctx.Done():
+					return
+				}
+
+				backoff := minConnectBackoff
+				for {
+					err := c.pushUpdate(aggregation, srv, w)
+
+					// The backoff only grows while the changestream can not be started
+					if w.isRunning() {
+						backoff = minConnectBackoff
+					}
+
+					w.setRunning(false, err)
+					if c.ctx.Err() != nil {
+						return
+					}
+
+					c.logger.Errorf("%v; failed to watch for updates, fallback to pull, retry in %s", err, backoff)
+
+					select {
+					case <-time.After(backoff):
+					case <-c.ctx.Done():
+						return
+					}
 
-				if err != nil {
-					c.logger.Errorf("%s; failed to watch for updates, fallback to pull", err)
+					backoff *= 2
+					if backoff > maxConnectBackoff {
+						backoff = maxConnectBackoff
+					}
 				}
 			}(aggregation, srv)
 		}
@@ -333,8 +706,23 @@ func (c *Collector) StartCacheInvalidator() error {
 	return nil
 }
 
-func (c *Collector) pushUpdate(aggregation *Aggregation, srv *server) error {
-	ctx := context.Background()
+// Reports whether a running changestream watcher invalidates the cached results of the aggregation on the server
+func (c *Collector) isWatched(aggregation *Aggregation, srv *server) bool {
+	c.mutex.Lock()
+	watchers := c.watchers
+	c.mutex.Unlock()
+
+	for _, w := range watchers {
+		if w.source == aggregation && w.server == srv.name {
+			return w.isRunning()
+		}
+	}
+
+	return false
+}
+
+func (c *Collector) pushUpdate(aggregation *Aggregation, srv *server, w *watcher) error {
+	ctx := c.ctx
 
 	c.logger.Infof("start changestream on %s.%s, waiting for changes", aggregation.Database, aggregation.Collection)
 	cursor, err := srv.driver.Watch(ctx, aggregation.Database, aggregation.Collection, bson.A{})
@@ -343,9 +731,16 @@ func (c *Collector) pushUpdate(aggregation *Aggregation, srv *server) error {
 		return fmt.Errorf("failed to start changestream listener %s", err)
 	}
 
-	defer cursor.Close(ctx)
+	// The collector context is cancelled on shutdown, the change stream must still be closed on the server
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.QueryTimeout)
+		defer cancel()
+		_ = cursor.Close(ctx)
+	}()
+
+	w.setRunning(true, nil)
 
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(ctx) {
 		var result ChangeStreamEvent
 
 		err := cursor.Decode(&result)
@@ -356,28 +751,58 @@ func (c *Collector) pushUpdate(aggregation *Aggregation, srv *server) error {
 
 		//Invalidate cached entry, aggregation must be executed during the next scrape
 		c.mutex.Lock()
-		delete(c.cache, aggregation.Pipeline+srv.name)
+		delete(c.cache, cacheKey{aggregation, srv.name, aggregation.namespace()})
 		c.mutex.Unlock()
 	}
 
-	return nil
+	return cursor.Err()
 }
 
-func (c *Collector) aggregate(aggregation *Aggregation, srv *server, ch chan<- prometheus.Metric) error {
-	c.logger.Debugf("run aggregation %s on server %s", aggregation.Pipeline, srv.name)
+func (c *Collector) aggregate(ctx context.Context, aggregation *Aggregation, srv *server, ns namespace) ([]prometheus.Metric, error) {
+	metrics, _, err := c.execute(ctx, aggregation, srv, ns)
+	return metrics, err
+}
+
+// Run the aggregation and return the generated metrics and the number of documents returned
+func (c *Collector) execute(ctx context.Context, aggregation *Aggregation, srv *server, ns namespace) (metrics []prometheus.Metric, i int, err error) {
+	c.logger.Debugf("run aggregation %s on server %s (%s.%s)", aggregation.Pipeline, srv.name, ns.database, ns.collection)
+
+	timeout := c.timeout(aggregation)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.config.QueryTimeout)
+	scrapeCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout+maxTimeGrace)
 	defer cancel()
 
-	cursor, err := srv.driver.Aggregate(ctx, aggregation.Database, aggregation.Collection, aggregation.pipeline)
+	// The server did not respond in time, the deadline of the scrape itself is reported by the caller
+	defer func() {
+		if err != nil && scrapeCtx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w: %s", ErrQueryTimeout, err)
+		}
+	}()
+
+	// Propagate the timeout to the server (maxTimeMS) so the operation gets aborted on the server as well
+	opts := *aggregation.queryOptions
+	opts.MaxTime = timeout
+
+	var cursor Cursor
+	if aggregation.Kind == KindCommand {
+		cursor, err = c.runCommand(ctx, aggregation, srv, ns, &opts)
+	} else {
+		cursor, err = srv.driver.Aggregate(ctx, ns.database, ns.collection, aggregation.pipeline, &opts)
+	}
+
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
+	defer cursor.Close(ctx)
+
 	var multierr *multierror.Error
-	var i int
 	var result = make(AggregationResult)
-	var metrics []prometheus.Metric
+	labels := aggregation.labelValues(srv, ns)
 
 	for cursor.Next(ctx) {
 		i++
@@ -392,16 +817,19 @@ func (c *Collector) aggregate(aggregation *Aggregation, srv *server, ch chan<- p
 		}
 
 		for _, metric := range aggregation.Metrics {
-			m, err := createMetric(srv, metric, result)
+			m, err := createMetric(labels, metric, result)
 			if err != nil {
-				return err
+				return metrics, i, err
 			}
 
 			metrics = append(metrics, m)
-			ch <- m
 		}
 	}
 
+	if err := cursor.Err(); err != nil {
+		return metrics, i, err
+	}
+
 	if i == 0 {
 		for _, metric := range aggregation.Metrics {
 			if !metric.OverrideEmpty {
@@ -414,20 +842,60 @@ func (c *Collector) aggregate(aggregation *Aggregation, srv *server, ch chan<- p
 				result[label] = ""
 			}
 
-			m, err := createMetric(srv, metric, result)
+			m, err := createMetric(labels, metric, result)
 			if err != nil {
-				return err
+				return metrics, i, err
+			}
+
+			metrics = append(metrics, m)
+		}
+	}
+
+	c.updateCache(aggregation, srv, ns, metrics)
+	return metrics, i, multierr.ErrorOrNil()
+}
+
+// Execute a database command and return a cursor over either the result document itself
+// or over the documents of the array found at the configured result path
+func (c *Collector) runCommand(ctx context.Context, aggregation *Aggregation, srv *server, ns namespace, opts *QueryOptions) (Cursor, error) {
+	result, err := srv.driver.RunCommand(ctx, ns.database, aggregation.command, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if aggregation.ResultPath == "" {
+		return &resultCursor{results: []AggregationResult{result}}, nil
+	}
+
+	val, ok := result.lookup(aggregation.ResultPath)
+	if !ok {
+		return nil, fmt.Errorf("result path %s not found in command result", aggregation.ResultPath)
+	}
+
+	var results []AggregationResult
+	switch v := val.(type) {
+	case bson.A:
+		for _, elem := range v {
+			doc, ok := toAggregationResult(elem)
+			if !ok {
+				return nil, fmt.Errorf("result path %s must contain documents only, type %T given", aggregation.ResultPath, elem)
 			}
 
-			ch <- m
+			results = append(results, doc)
 		}
+	default:
+		doc, ok := toAggregationResult(v)
+		if !ok {
+			return nil, fmt.Errorf("result path %s must point to an array or document, type %T given", aggregation.ResultPath, val)
+		}
+
+		results = append(results, doc)
 	}
 
-	c.updateCache(aggregation, srv, metrics)
-	return multierr.ErrorOrNil()
+	return &resultCursor{results: results}, nil
 }
 
-func createMetric(srv *server, metric *Metric, result AggregationResult) (prometheus.Metric, error) {
+func createMetric(constLabels []string, metric *Metric, result AggregationResult) (prometheus.Metric, error) {
 	var (
 		value float64
 		err   error
@@ -448,12 +916,12 @@ func createMetric(srv *server, metric *Metric, result AggregationResult) (promet
 		return nil, err
 	}
 
-	labels = append([]string{srv.name}, labels...)
+	labels = append(append([]string{}, constLabels...), labels...)
 	return prometheus.NewConstMetric(metric.desc, prometheus.GaugeValue, value, labels...)
 }
 
 func (metric *Metric) getValue(result AggregationResult) (float64, error) {
-	if val, ok := result[metric.Value]; ok {
+	if val, ok := result.lookup(metric.Value); ok {
 		switch v := val.(type) {
 		case float32:
 			value := float64(v)
@@ -478,7 +946,7 @@ func (metric *Metric) getLabels(result AggregationResult) ([]string, error) {
 	var labels []string
 
 	for _, label := range metric.Labels {
-		if val, ok := result[label]; ok {
+		if val, ok := result.lookup(label); ok {
 			switch v := val.(type) {
 			case string:
 				labels = append(labels, v)
@@ -1,6 +1,9 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -8,6 +11,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func buildMockDriver(docs []interface{}) *mockMongoDBDriver {
@@ -26,6 +31,7 @@ type aggregationTest struct {
 	expected       string
 	expectedCached string
 	docs           []interface{}
+	commandResult  AggregationResult
 }
 
 func TestInitializeMetrics(t *testing.T) {
@@ -388,6 +394,478 @@ func TestInitializeMetrics(t *testing.T) {
 	}
 }
 
+func TestCommandMetrics(t *testing.T) {
+	var tests = []aggregationTest{
+		{
+			name: "Invalid command must end in error",
+			aggregation: &Aggregation{
+				Kind:    KindCommand,
+				Command: "{",
+			},
+			error: "failed to decode json command: invalid JSON input",
+		},
+		{
+			name: "Empty command must end in error",
+			aggregation: &Aggregation{
+				Kind:    KindCommand,
+				Command: "{}",
+			},
+			error: "command must not be empty",
+		},
+		{
+			name: "Unknown aggregation kind must end in error",
+			aggregation: &Aggregation{
+				Kind: "find",
+			},
+			error: "unknown aggregation kind find provided. Only [aggregate, command] are valid options",
+		},
+		{
+			name: "Command result document is used as result",
+			aggregation: &Aggregation{
+				Kind:    KindCommand,
+				Command: `{"dbStats": 1}`,
+				Metrics: []*Metric{
+					{
+						Name:   "db_objects",
+						Type:   "gauge",
+						Help:   "foobar",
+						Value:  "objects",
+						Labels: []string{"db"},
+					},
+				},
+			},
+			commandResult: AggregationResult{
+				"db":      "mydb",
+				"objects": int32(12),
+			},
+			expected: `
+				# HELP db_objects foobar
+				# TYPE db_objects gauge
+				db_objects{db="mydb",server="main"} 12
+			`,
+		},
+		{
+			name: "Values from embedded documents are looked up by path",
+			aggregation: &Aggregation{
+				Kind:    KindCommand,
+				Command: `{"serverStatus": 1}`,
+				Metrics: []*Metric{
+					{
+						Name:  "connections_current",
+						Type:  "gauge",
+						Help:  "foobar",
+						Value: "connections.current",
+					},
+				},
+			},
+			commandResult: AggregationResult{
+				"connections": AggregationResult{
+					"current": int64(5),
+				},
+			},
+			expected: `
+				# HELP connections_current foobar
+				# TYPE connections_current gauge
+				connections_current{server="main"} 5
+			`,
+		},
+		{
+			name: "Each document of an array within the command result is used as result",
+			aggregation: &Aggregation{
+				Kind:       KindCommand,
+				Command:    `{"replSetGetStatus": 1}`,
+				ResultPath: "members",
+				Metrics: []*Metric{
+					{
+						Name:   "member_health",
+						Type:   "gauge",
+						Help:   "foobar",
+						Value:  "health",
+						Labels: []string{"name"},
+					},
+				},
+			},
+			commandResult: AggregationResult{
+				"members": bson.A{
+					bson.D{{Key: "name", Value: "foo:27017"}, {Key: "health", Value: float64(1)}},
+					bson.D{{Key: "name", Value: "bar:27017"}, {Key: "health", Value: float64(0)}},
+				},
+			},
+			expected: `
+				# HELP member_health foobar
+				# TYPE member_health gauge
+				member_health{name="bar:27017",server="main"} 0
+				member_health{name="foo:27017",server="main"} 1
+			`,
+		},
+		{
+			name:    "Result path not found in command result results in an ERROR counter",
+			counter: true,
+			aggregation: &Aggregation{
+				Kind:       KindCommand,
+				Command:    `{"replSetGetStatus": 1}`,
+				ResultPath: "members",
+				Metrics: []*Metric{
+					{
+						Name:  "member_health",
+						Type:  "gauge",
+						Value: "health",
+					},
+				},
+			},
+			commandResult: AggregationResult{},
+			expected: `
+			# HELP counter_total mongodb query stats
+			# TYPE counter_total counter
+			counter_total{aggregation="aggregation_0",result="ERROR",server="main"} 1
+			`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drv := buildMockDriver(test.docs)
+			drv.CommandResult = test.commandResult
+			var c *Collector
+			reg := prometheus.NewRegistry()
+
+			if test.counter == true {
+				c = New(WithCounter(prometheus.NewCounterVec(
+					prometheus.CounterOpts{
+						Name: "counter_total",
+						Help: "mongodb query stats",
+					},
+					[]string{"aggregation", "server", "result"},
+				)))
+			} else {
+				c = New()
+			}
+
+			assert.NoError(t, c.RegisterServer("main", drv))
+
+			if test.error != "" {
+				assert.EqualError(t, c.RegisterAggregation(test.aggregation), test.error)
+				return
+			}
+
+			assert.NoError(t, reg.Register(c))
+			assert.NoError(t, c.RegisterAggregation(test.aggregation))
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(test.expected)))
+		})
+	}
+}
+
+func TestNamespacePatterns(t *testing.T) {
+	var tests = []aggregationTest{
+		{
+			name: "Invalid database pattern must end in error",
+			aggregation: &Aggregation{
+				DatabasePattern: "/[/",
+				Pipeline:        "[]",
+			},
+			error: "failed to compile database pattern: error parsing regexp: missing closing ]: `[`",
+		},
+		{
+			name: "Patterns are not supported in push mode",
+			aggregation: &Aggregation{
+				Mode:            ModePush,
+				DatabasePattern: "tenant_*",
+				Pipeline:        "[]",
+			},
+			error: "database and collection patterns are not supported in push mode",
+		},
+		{
+			name: "Aggregation is executed for each database matching a glob pattern",
+			aggregation: &Aggregation{
+				DatabasePattern: "tenant_*",
+				Collection:      "objects",
+				Pipeline:        "[{\"$count\":\"total\"}]",
+				Metrics: []*Metric{
+					{
+						Name:  "objects_total",
+						Type:  "gauge",
+						Help:  "foobar",
+						Value: "total",
+					},
+				},
+			},
+			docs: []interface{}{AggregationResult{
+				"total": float64(1),
+			}},
+			expected: `
+				# HELP objects_total foobar
+				# TYPE objects_total gauge
+				objects_total{database="tenant_bar",server="main"} 1
+				objects_total{database="tenant_foo",server="main"} 1
+			`,
+		},
+		{
+			name: "Aggregation is executed for each database and collection matching a regex pattern",
+			aggregation: &Aggregation{
+				DatabasePattern:   "/^tenant_(foo|bar)$/",
+				CollectionPattern: "/^events_/",
+				Pipeline:          "[{\"$count\":\"total\"}]",
+				Metrics: []*Metric{
+					{
+						Name:  "events_total",
+						Type:  "gauge",
+						Help:  "foobar",
+						Value: "total",
+					},
+				},
+			},
+			docs: []interface{}{AggregationResult{
+				"total": float64(2),
+			}},
+			expected: `
+				# HELP events_total foobar
+				# TYPE events_total gauge
+				events_total{collection="events_1",database="tenant_bar",server="main"} 2
+				events_total{collection="events_1",database="tenant_foo",server="main"} 2
+				events_total{collection="events_2",database="tenant_foo",server="main"} 2
+			`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drv := buildMockDriver(test.docs)
+			drv.Databases = []string{"admin", "tenant_foo", "tenant_bar", "other"}
+			drv.Collections = map[string][]string{
+				"tenant_foo": {"events_1", "events_2", "objects"},
+				"tenant_bar": {"events_1", "objects"},
+			}
+
+			c := New()
+			assert.NoError(t, c.RegisterServer("main", drv))
+
+			if test.error != "" {
+				assert.EqualError(t, c.RegisterAggregation(test.aggregation), test.error)
+				return
+			}
+
+			assert.NoError(t, c.RegisterAggregation(test.aggregation))
+			assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(test.expected)))
+			assert.Equal(t, 10*time.Second, drv.ListOptions.MaxTime)
+		})
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	t.Run("Invalid read preference must end in error", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+		assert.EqualError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline:       "[]",
+			ReadPreference: "foo",
+		}), "invalid read preference: unknown read preference foo")
+	})
+
+	t.Run("Invalid read concern must end in error", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+		assert.EqualError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline:    "[]",
+			ReadConcern: "foo",
+		}), "invalid read concern: unknown read concern foo provided. Only [local, available, majority, linearizable, snapshot] are valid options")
+	})
+
+	t.Run("Invalid json hint must end in error", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+		assert.Error(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Hint:     "{",
+		}))
+	})
+
+	t.Run("Query options are passed to the driver", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{})
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline:       "[]",
+			ReadPreference: "secondaryPreferred",
+			ReadConcern:    "majority",
+			AllowDiskUse:   true,
+			Hint:           `{"created": -1}`,
+			BatchSize:      100,
+			Comment:        "exporter",
+		}))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader("")))
+
+		assert.Equal(t, "secondaryPreferred", drv.QueryOptions.ReadPreference.Mode().String())
+		assert.Equal(t, "majority", drv.QueryOptions.ReadConcern.Level)
+		assert.Equal(t, true, drv.QueryOptions.AllowDiskUse)
+		assert.Equal(t, bson.D{{Key: "created", Value: int32(-1)}}, drv.QueryOptions.Hint)
+		assert.Equal(t, int32(100), drv.QueryOptions.BatchSize)
+		assert.Equal(t, "exporter", drv.QueryOptions.Comment)
+		assert.Equal(t, 10*time.Second, drv.QueryOptions.MaxTime)
+	})
+
+	t.Run("Aggregation timeout overrides the global query timeout", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{})
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Timeout:  2 * time.Second,
+		}))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader("")))
+		assert.Equal(t, 2*time.Second, drv.QueryOptions.MaxTime)
+	})
+
+	t.Run("Server side timeouts are counted separately", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{})
+		drv.Error = mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "counter_total",
+				Help: "mongodb query stats",
+			},
+			[]string{"aggregation", "server", "result"},
+		)
+
+		c := New(WithCounter(counter))
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+		}))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+			# HELP counter_total mongodb query stats
+			# TYPE counter_total counter
+			counter_total{aggregation="aggregation_0",result="TIMEOUT",server="main"} 1
+		`)))
+	})
+
+	t.Run("Queries exceeding the client side deadline are counted as timeouts", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{})
+		drv.Delay = 5 * time.Second
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "counter_total",
+				Help: "mongodb query stats",
+			},
+			[]string{"aggregation", "server", "result"},
+		)
+
+		c := New(WithCounter(counter))
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Timeout:  50 * time.Millisecond,
+		}))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+			# HELP counter_total mongodb query stats
+			# TYPE counter_total counter
+			counter_total{aggregation="aggregation_0",result="TIMEOUT",server="main"} 1
+		`)))
+	})
+
+	t.Run("Hint is used as index name if not a json document", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{})
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Hint:     "created_1",
+		}))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader("")))
+		assert.Equal(t, "created_1", drv.QueryOptions.Hint)
+	})
+}
+
+func TestScrapeDeadline(t *testing.T) {
+	t.Run("Aggregations not finished until the scrape deadline are skipped", func(t *testing.T) {
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "counter_total",
+				Help: "mongodb query stats",
+			},
+			[]string{"aggregation", "server", "result"},
+		)
+
+		slow := buildMockDriver([]interface{}{AggregationResult{"total": float64(2)}})
+		slow.Delay = 5 * time.Second
+		fast := buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})
+
+		c := New(WithCounter(counter))
+		assert.NoError(t, c.RegisterServer("fast", fast))
+		assert.NoError(t, c.RegisterServer("slow", slow))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Metrics: []*Metric{
+				{
+					Name:  "simple",
+					Type:  "gauge",
+					Value: "total",
+					Help:  "foobar",
+				},
+			},
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		assert.NoError(t, testutil.CollectAndCompare(c.WithContext(ctx), strings.NewReader(`
+			# HELP counter_total mongodb query stats
+			# TYPE counter_total counter
+			counter_total{aggregation="aggregation_0",result="SCRAPE_TIMEOUT",server="slow"} 1
+			counter_total{aggregation="aggregation_0",result="SUCCESS",server="fast"} 1
+			# HELP simple foobar
+			# TYPE simple gauge
+			simple{server="fast"} 1
+		`)))
+	})
+
+	t.Run("Namespace discovery exceeding the scrape deadline is counted as scrape timeout", func(t *testing.T) {
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "counter_total",
+				Help: "mongodb query stats",
+			},
+			[]string{"aggregation", "server", "result"},
+		)
+
+		slow := buildMockDriver([]interface{}{AggregationResult{"total": float64(2)}})
+		slow.Databases = []string{"tenant_foo"}
+		slow.ListDelay = 5 * time.Second
+		fast := buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})
+		fast.Databases = []string{"tenant_foo"}
+
+		c := New(WithCounter(counter))
+		assert.NoError(t, c.RegisterServer("slow", slow))
+		assert.NoError(t, c.RegisterServer("fast", fast))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			DatabasePattern: "tenant_*",
+			Collection:      "events",
+			Pipeline:        "[]",
+			Metrics: []*Metric{
+				{
+					Name:  "simple",
+					Type:  "gauge",
+					Value: "total",
+					Help:  "foobar",
+				},
+			},
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		// The fast server is discovered and aggregated although it is registered after the slow one
+		assert.NoError(t, testutil.CollectAndCompare(c.WithContext(ctx), strings.NewReader(`
+			# HELP counter_total mongodb query stats
+			# TYPE counter_total counter
+			counter_total{aggregation="aggregation_0",result="SCRAPE_TIMEOUT",server="slow"} 1
+			counter_total{aggregation="aggregation_0",result="SUCCESS",server="fast"} 1
+			# HELP simple foobar
+			# TYPE simple gauge
+			simple{database="tenant_foo",server="fast"} 1
+		`)))
+	})
+}
+
 func TestCachedMetric(t *testing.T) {
 	var tests = []aggregationTest{
 		{
@@ -401,7 +879,7 @@ func TestCachedMetric(t *testing.T) {
 						Help:  "foobar",
 					},
 				},
-				Cache:    0,
+				Cache:    duration(0),
 				Pipeline: "[{\"$match\":{\"foo\":\"bar\"}}]",
 			},
 			docs: []interface{}{AggregationResult{
@@ -429,7 +907,7 @@ func TestCachedMetric(t *testing.T) {
 						Help:  "Cached for 60s",
 					},
 				},
-				Cache:    60 * time.Second,
+				Cache:    duration(60 * time.Second),
 				Pipeline: "[{\"$match\":{\"foo\":\"bar\"}}]",
 			},
 			docs: []interface{}{AggregationResult{
@@ -464,4 +942,932 @@ func TestCachedMetric(t *testing.T) {
 			assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(test.expectedCached)))
 		})
 	}
+
+	t.Run("Aggregations with the same pipeline are cached separately", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+
+		for _, name := range []string{"a", "b"} {
+			assert.NoError(t, c.RegisterAggregation(&Aggregation{
+				Name:     name,
+				Cache:    duration(60 * time.Second),
+				Pipeline: "[]",
+				Metrics: []*Metric{
+					{
+						Name:  "m_" + name,
+						Type:  "gauge",
+						Value: "total",
+						Help:  "foobar",
+					},
+				},
+			}))
+		}
+
+		expected := `
+			# HELP m_a foobar
+			# TYPE m_a gauge
+			m_a{server="main"} 1
+			# HELP m_b foobar
+			# TYPE m_b gauge
+			m_b{server="main"} 1
+		`
+
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+	})
+}
+
+func TestServerMonitor(t *testing.T) {
+	buildAggregation := func() *Aggregation {
+		return &Aggregation{
+			Pipeline: "[]",
+			Metrics: []*Metric{
+				{
+					Name:  "total",
+					Type:  "gauge",
+					Value: "total",
+					Help:  "foobar",
+				},
+			},
+		}
+	}
+
+	t.Run("Aggregations of a server which failed to connect are skipped", func(t *testing.T) {
+		up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "server_up", Help: "up"}, []string{"server"})
+		c := New(WithServerUp(up))
+		defer c.cancel()
+
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}}), WithConnect(func(ctx context.Context) error {
+			return errors.New("connection refused")
+		})))
+		assert.NoError(t, c.RegisterAggregation(buildAggregation()))
+		assert.NoError(t, c.StartServerMonitor())
+
+		assert.Eventually(t, func() bool { return testutil.CollectAndCount(up) == 1 }, time.Second, 10*time.Millisecond)
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP server_up up
+# TYPE server_up gauge
+server_up{server="main"} 0
+`)))
+	})
+
+	t.Run("Aggregations are executed once the server is connected", func(t *testing.T) {
+		up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "server_up", Help: "up"}, []string{"server"})
+		c := New(WithServerUp(up))
+		defer c.cancel()
+
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}}), WithConnect(func(ctx context.Context) error {
+			return nil
+		})))
+		assert.NoError(t, c.RegisterAggregation(buildAggregation()))
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader("")))
+		assert.NoError(t, c.StartServerMonitor())
+
+		assert.Eventually(t, func() bool { return testutil.CollectAndCount(up) == 1 }, time.Second, 10*time.Millisecond)
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP server_up up
+# TYPE server_up gauge
+server_up{server="main"} 1
+# HELP total foobar
+# TYPE total gauge
+total{server="main"} 1
+`)))
+	})
+}
+
+func TestStatus(t *testing.T) {
+	t.Run("Status reports servers and watchers", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+		assert.NoError(t, c.RegisterServer("lazy", buildMockDriver(nil), WithConnect(func(ctx context.Context) error {
+			return nil
+		})))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Mode:     ModePush,
+			Pipeline: "[]",
+			Servers:  []string{"lazy"},
+		}))
+		assert.NoError(t, c.StartCacheInvalidator())
+		defer c.cancel()
+
+		status := c.Status()
+		assert.Equal(t, []ServerStatus{{Name: "main", Up: true}, {Name: "lazy"}}, status.Servers)
+		assert.Equal(t, []WatcherStatus{{Aggregation: "aggregation_0", Server: "lazy"}}, status.Watchers)
+
+		b, err := json.Marshal(status.Servers[1])
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"lazy","up":false}`, string(b))
+	})
+
+	t.Run("Failed watchers fall back to pull and are restarted", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})
+		drv.ChangeStreamData = &mockCursor{Blocking: true}
+		drv.WatchError = errors.New("changestreams are not supported")
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Mode:     ModePush,
+			Pipeline: "[]",
+			Metrics: []*Metric{
+				{
+					Name:  "total",
+					Type:  "gauge",
+					Value: "total",
+					Help:  "foobar",
+				},
+			},
+		}))
+		assert.NoError(t, c.StartCacheInvalidator())
+		defer c.cancel()
+
+		assert.Eventually(t, func() bool { return c.Status().Watchers[0].Fallback }, time.Second, 10*time.Millisecond)
+		ready, err := c.Status().Ready(ReadinessAll)
+		assert.NoError(t, err)
+		assert.True(t, ready)
+		assert.Equal(t, "failed to start changestream listener changestreams are not supported", c.Status().Watchers[0].Error)
+
+		// Results are not cached while no changestream invalidates them
+		assert.Equal(t, 1, testutil.CollectAndCount(c))
+		c.mutex.Lock()
+		assert.Len(t, c.cache, 0)
+		c.mutex.Unlock()
+
+		drv.mutex.Lock()
+		drv.WatchError = nil
+		drv.mutex.Unlock()
+
+		assert.Eventually(t, func() bool { return c.Status().Watchers[0].Running }, 3*time.Second, 10*time.Millisecond)
+		assert.False(t, c.Status().Watchers[0].Fallback)
+		assert.Equal(t, 1, testutil.CollectAndCount(c))
+		c.mutex.Lock()
+		assert.Len(t, c.cache, 1)
+		c.mutex.Unlock()
+	})
+
+	t.Run("Last ping is reported once a server has been pinged", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil), WithConnect(func(ctx context.Context) error {
+			return nil
+		})))
+		assert.Nil(t, c.Status().Servers[0].LastPing)
+
+		before := time.Now()
+		assert.NoError(t, c.StartServerMonitor())
+		defer c.cancel()
+
+		assert.Eventually(t, func() bool { return c.Status().Servers[0].LastPing != nil }, time.Second, 10*time.Millisecond)
+		assert.False(t, c.Status().Servers[0].LastPing.Before(before))
+	})
+
+	t.Run("Readiness modes", func(t *testing.T) {
+		status := Status{
+			Servers: []ServerStatus{{Name: "main", Up: true}, {Name: "other"}},
+		}
+
+		ready, err := status.Ready(ReadinessAll)
+		assert.NoError(t, err)
+		assert.False(t, ready)
+
+		ready, err = status.Ready(ReadinessAny)
+		assert.NoError(t, err)
+		assert.True(t, ready)
+
+		status.Servers[1].Up = true
+		status.Watchers = []WatcherStatus{{Aggregation: "aggregation_0", Server: "main"}}
+		ready, err = status.Ready(ReadinessAll)
+		assert.NoError(t, err)
+		assert.False(t, ready)
+
+		_, err = status.Ready("foo")
+		assert.EqualError(t, err, "unknown readiness mode foo provided. Only [all, any] are valid options")
+	})
+}
+
+func TestProbe(t *testing.T) {
+	buildCollector := func(t *testing.T) *Collector {
+		c := New(WithTargetConnector(func(ctx context.Context, target string) (string, Driver, error) {
+			if target != "mongodb://adhoc:27017" {
+				return "", nil, errors.New("target not allowed")
+			}
+
+			return "adhoc:27017", buildMockDriver([]interface{}{AggregationResult{"total": float64(3)}}), nil
+		}))
+
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})))
+		assert.NoError(t, c.RegisterServer("other", buildMockDriver([]interface{}{AggregationResult{"total": float64(2)}})))
+
+		for _, group := range []string{"fast", "slow"} {
+			assert.NoError(t, c.RegisterAggregation(&Aggregation{
+				Pipeline: "[]",
+				Group:    group,
+				Metrics: []*Metric{
+					{
+						Name:  group,
+						Type:  "gauge",
+						Value: "total",
+						Help:  "foobar",
+					},
+				},
+			}))
+		}
+
+		return c
+	}
+
+	t.Run("Aggregations of a group are executed on a registered server", func(t *testing.T) {
+		c := buildCollector(t)
+		probe, release, err := c.Probe(context.Background(), "other", Filter{Groups: []string{"slow"}})
+		assert.NoError(t, err)
+		defer release()
+
+		assert.NoError(t, testutil.CollectAndCompare(probe, strings.NewReader(`
+# HELP slow foobar
+# TYPE slow gauge
+slow{server="other"} 2
+`)))
+	})
+
+	t.Run("Aggregations are executed on an ad-hoc target", func(t *testing.T) {
+		c := buildCollector(t)
+		probe, release, err := c.Probe(context.Background(), "mongodb://adhoc:27017")
+		assert.NoError(t, err)
+		defer release()
+
+		assert.NoError(t, testutil.CollectAndCompare(probe, strings.NewReader(`
+# HELP fast foobar
+# TYPE fast gauge
+fast{server="adhoc:27017"} 3
+# HELP slow foobar
+# TYPE slow gauge
+slow{server="adhoc:27017"} 3
+`)))
+	})
+
+	t.Run("Target which is not allowed fails", func(t *testing.T) {
+		c := buildCollector(t)
+		_, _, err := c.Probe(context.Background(), "mongodb://foo:27017")
+		assert.EqualError(t, err, "target not allowed")
+	})
+
+	t.Run("Unknown target without connector fails", func(t *testing.T) {
+		c := New()
+		_, _, err := c.Probe(context.Background(), "foo")
+		assert.EqualError(t, err, "unknown target foo")
+	})
+
+	t.Run("Results of an ad-hoc target are neither cached nor counted", func(t *testing.T) {
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "counter_total",
+				Help: "mongodb query stats",
+			},
+			[]string{"aggregation", "server", "result"},
+		)
+
+		total := float64(1)
+		c := New(WithCounter(counter), WithTargetConnector(func(ctx context.Context, target string) (string, Driver, error) {
+			return "adhoc:27017", buildMockDriver([]interface{}{AggregationResult{"total": total}}), nil
+		}))
+
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Cache:    duration(-1),
+			Metrics: []*Metric{
+				{
+					Name:  "total",
+					Type:  "gauge",
+					Value: "total",
+					Help:  "foobar",
+				},
+			},
+		}))
+
+		for _, expected := range []string{"1", "2"} {
+			probe, release, err := c.Probe(context.Background(), "mongodb://adhoc:27017")
+			assert.NoError(t, err)
+
+			assert.NoError(t, testutil.CollectAndCompare(probe, strings.NewReader(`
+# HELP total foobar
+# TYPE total gauge
+total{server="adhoc:27017"} `+expected+`
+`)))
+
+			release()
+			total++
+		}
+
+		assert.Len(t, c.cache, 0)
+		assert.Equal(t, 0, testutil.CollectAndCount(counter))
+	})
+}
+
+func TestFilter(t *testing.T) {
+	buildCollector := func(t *testing.T, counter *prometheus.CounterVec) *Collector {
+		c := New(WithCounter(counter))
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})))
+
+		for _, aggregation := range []*Aggregation{
+			{Name: "users", Group: "fast"},
+			{Name: "orders", Group: "slow"},
+			{Group: "slow"},
+		} {
+			aggregation.Pipeline = "[]"
+			aggregation.Metrics = []*Metric{
+				{
+					Name:        "total",
+					Type:        "gauge",
+					Value:       "total",
+					Help:        "foobar",
+					ConstLabels: prometheus.Labels{"aggregation": aggregation.label(len(c.aggregations))},
+				},
+			}
+
+			assert.NoError(t, c.RegisterAggregation(aggregation))
+		}
+
+		return c
+	}
+
+	t.Run("Only aggregations of a group are collected", func(t *testing.T) {
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "query_total", Help: "query"}, []string{"aggregation", "server", "result"})
+		c := buildCollector(t, counter)
+
+		assert.NoError(t, testutil.CollectAndCompare(c.WithFilter(context.Background(), Filter{Groups: []string{"slow"}}), strings.NewReader(`
+# HELP query_total query
+# TYPE query_total counter
+query_total{aggregation="aggregation_2",result="SUCCESS",server="main"} 1
+query_total{aggregation="orders",result="SUCCESS",server="main"} 1
+# HELP total foobar
+# TYPE total gauge
+total{aggregation="aggregation_2",server="main"} 1
+total{aggregation="orders",server="main"} 1
+`)))
+	})
+
+	t.Run("Aggregations must match all filters", func(t *testing.T) {
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "query_total", Help: "query"}, []string{"aggregation", "server", "result"})
+		c := buildCollector(t, counter)
+
+		assert.NoError(t, testutil.CollectAndCompare(c.WithFilter(context.Background(), Filter{Groups: []string{"slow"}}, Filter{Aggregations: []string{"orders", "users"}}), strings.NewReader(`
+# HELP query_total query
+# TYPE query_total counter
+query_total{aggregation="orders",result="SUCCESS",server="main"} 1
+# HELP total foobar
+# TYPE total gauge
+total{aggregation="orders",server="main"} 1
+`)))
+	})
+
+	t.Run("Unnamed aggregations are matched by their position", func(t *testing.T) {
+		counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "query_total", Help: "query"}, []string{"aggregation", "server", "result"})
+		c := buildCollector(t, counter)
+
+		assert.NoError(t, testutil.CollectAndCompare(c.WithFilter(context.Background(), Filter{Aggregations: []string{"aggregation_2"}}), strings.NewReader(`
+# HELP query_total query
+# TYPE query_total counter
+query_total{aggregation="aggregation_2",result="SUCCESS",server="main"} 1
+# HELP total foobar
+# TYPE total gauge
+total{aggregation="aggregation_2",server="main"} 1
+`)))
+
+		results := c.Run(context.Background(), Filter{Aggregations: []string{"aggregation_2"}})
+		assert.Len(t, results, 1)
+		assert.Equal(t, "aggregation_2", results[0].Aggregation)
+	})
+
+	t.Run("Duplicate aggregation name must end in error", func(t *testing.T) {
+		c := buildCollector(t, nil)
+		assert.EqualError(t, c.RegisterAggregation(&Aggregation{
+			Name:     "users",
+			Pipeline: "[]",
+		}), "aggregation users is already registered")
+	})
+}
+
+func TestAdopt(t *testing.T) {
+	buildAggregation := func(value string) *Aggregation {
+		return &Aggregation{
+			Pipeline: "[]",
+			Cache:    duration(time.Minute),
+			Metrics: []*Metric{
+				{
+					Name:  "total",
+					Type:  "gauge",
+					Value: value,
+					Help:  "foobar",
+				},
+			},
+		}
+	}
+
+	t.Run("Unchanged servers and aggregations are taken over", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{AggregationResult{"total": float64(1)}})
+		previous := New()
+		assert.NoError(t, previous.RegisterServer("main", drv, WithFingerprint("main")))
+		assert.NoError(t, previous.RegisterAggregation(buildAggregation("total")))
+		assert.NoError(t, testutil.CollectAndCompare(previous, strings.NewReader(`
+# HELP total foobar
+# TYPE total gauge
+total{server="main"} 1
+`)))
+
+		// The new driver would return a different value if the cache was not taken over
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver([]interface{}{AggregationResult{"total": float64(2)}}), WithFingerprint("main"), WithConnect(func(ctx context.Context) error {
+			return errors.New("must not connect")
+		})))
+		assert.NoError(t, c.RegisterAggregation(buildAggregation("total")))
+
+		c.Adopt(previous)
+		assert.NoError(t, previous.Shutdown(context.Background()))
+		assert.False(t, drv.Disconnected)
+		assert.True(t, c.servers[0].isUp())
+		assert.Equal(t, Driver(drv), c.servers[0].driver)
+
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP total foobar
+# TYPE total gauge
+total{server="main"} 1
+`)))
+	})
+
+	t.Run("Changed servers and aggregations are not taken over", func(t *testing.T) {
+		drv := buildMockDriver([]interface{}{AggregationResult{"total": float64(1), "count": float64(3)}})
+		previous := New()
+		assert.NoError(t, previous.RegisterServer("main", drv, WithFingerprint("main")))
+		assert.NoError(t, previous.RegisterAggregation(buildAggregation("total")))
+		assert.Equal(t, 1, testutil.CollectAndCount(previous))
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil), WithFingerprint("changed"), WithConnect(func(ctx context.Context) error {
+			return nil
+		})))
+		assert.NoError(t, c.RegisterAggregation(buildAggregation("count")))
+
+		c.Adopt(previous)
+		assert.NoError(t, previous.Shutdown(context.Background()))
+		assert.True(t, drv.Disconnected)
+		assert.False(t, c.servers[0].isUp())
+		assert.Len(t, c.cache, 0)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("Change streams are closed and servers disconnected", func(t *testing.T) {
+		drv := buildMockDriver(nil)
+		drv.ChangeStreamData = &mockCursor{Blocking: true}
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Mode:     ModePush,
+			Pipeline: "[]",
+		}))
+		assert.NoError(t, c.StartServerMonitor())
+		assert.NoError(t, c.StartCacheInvalidator())
+
+		assert.Eventually(t, func() bool {
+			ready, _ := c.Status().Ready(ReadinessAll)
+			return ready
+		}, time.Second, 10*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		assert.NoError(t, c.Shutdown(ctx))
+		assert.True(t, drv.ChangeStreamData.Closed)
+		assert.True(t, drv.Disconnected)
+	})
+
+	t.Run("Shutdown fails if background tasks do not stop in time", func(t *testing.T) {
+		block := make(chan struct{})
+		defer close(block)
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil), WithWatch(func(ctx context.Context, d Driver) {
+			<-block
+		})))
+		assert.NoError(t, c.StartServerMonitor())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := c.Shutdown(ctx)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "background tasks did not stop in time")
+	})
+}
+
+func TestValidateMetrics(t *testing.T) {
+	register := func(c *Collector, metrics ...*Metric) error {
+		return c.RegisterAggregation(&Aggregation{
+			Pipeline: "[]",
+			Metrics:  metrics,
+		})
+	}
+
+	t.Run("Invalid metric and label names fail", func(t *testing.T) {
+		c := New()
+		assert.EqualError(t, register(c, &Metric{Name: "foo-bar"}), `invalid metric foo-bar: invalid metric name "foo-bar"`)
+		assert.EqualError(t, register(c, &Metric{Name: "foo", Labels: []string{"a-b"}}), `invalid metric foo: invalid label name "a-b"`)
+		assert.EqualError(t, register(c, &Metric{Name: "foo", ConstLabels: prometheus.Labels{"__name": "x"}}), `invalid metric foo: invalid label name "__name"`)
+		assert.EqualError(t, register(c, &Metric{Name: "foo", Labels: []string{"a"}, ConstLabels: prometheus.Labels{"a": "x"}}), `invalid metric foo: duplicate label name "a"`)
+		assert.EqualError(t, register(c, &Metric{Name: "foo", Labels: []string{"server"}}), "invalid metric foo: label server is added by the exporter")
+		assert.True(t, errors.Is(register(c, &Metric{Name: "foo", Type: "counter"}), ErrInvalidType))
+	})
+
+	t.Run("Metrics with the same name must have the same help and labels", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, register(c, &Metric{Name: "foo", Help: "foo", Labels: []string{"a"}}))
+		assert.NoError(t, register(c, &Metric{Name: "foo", Help: "foo", Labels: []string{"a"}}))
+		assert.EqualError(t, register(c, &Metric{Name: "foo", Help: "bar", Labels: []string{"a"}}), "metric foo is already defined with a different help or different labels")
+		assert.EqualError(t, register(c, &Metric{Name: "foo", Help: "foo", Labels: []string{"b"}}), "metric foo is already defined with a different help or different labels")
+		assert.EqualError(t, register(c, &Metric{Name: "bar"}, &Metric{Name: "bar", Help: "bar"}), "metric bar is already defined with a different help or different labels")
+	})
+
+	t.Run("All invalid settings of an aggregation are reported", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+		err := c.RegisterAggregation(&Aggregation{
+			Servers:  []string{"other"},
+			Mode:     "foo",
+			Pipeline: "[{",
+			Metrics:  []*Metric{{Name: "foo-bar"}, {Name: "foo", Labels: []string{"server"}}},
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, []string{
+			"aggregation bound to server other which has not been found",
+			"unknown aggregation mode foo provided. Only [pull, push] are valid options",
+			"failed to decode json aggregation pipeline: invalid JSON input; unexpected end of input at position 0",
+			`invalid metric foo-bar: invalid metric name "foo-bar"`,
+			"invalid metric foo: label server is added by the exporter",
+		}, strings.Split(err.Error(), "\n"))
+	})
+
+	t.Run("Aggregation bound to an unknown server fails", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+		assert.EqualError(t, c.RegisterAggregation(&Aggregation{
+			Servers:  []string{"main", "other"},
+			Pipeline: "[]",
+		}), "aggregation bound to server other which has not been found")
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Aggregations are executed once and reported per server", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver([]interface{}{
+			AggregationResult{"total": float64(1)},
+			AggregationResult{"total": float64(2)},
+		})))
+		assert.NoError(t, c.RegisterServer("lazy", buildMockDriver(nil), WithConnect(func(ctx context.Context) error {
+			return errors.New("connection refused")
+		})))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Name:       "users",
+			Database:   "db",
+			Collection: "users",
+			Pipeline:   "[]",
+			Metrics: []*Metric{
+				{
+					Name:  "total",
+					Value: "total",
+				},
+			},
+		}))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Name:     "other",
+			Pipeline: "[]",
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		results := c.Run(ctx, Filter{Aggregations: []string{"users"}})
+		assert.Len(t, results, 2)
+
+		assert.Equal(t, "users", results[0].Aggregation)
+		assert.Equal(t, "main", results[0].Server)
+		assert.Equal(t, "db", results[0].Database)
+		assert.Equal(t, "users", results[0].Collection)
+		assert.Equal(t, 2, results[0].Rows)
+		assert.Len(t, results[0].Metrics, 2)
+		assert.NoError(t, results[0].Err)
+
+		assert.Equal(t, "lazy", results[1].Server)
+		assert.True(t, errors.Is(results[1].Err, ErrNotConnected))
+	})
+}
+
+func TestFixtureDriver(t *testing.T) {
+	t.Run("Fixtures are returned per aggregation and namespace", func(t *testing.T) {
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil), WithConnect(func(ctx context.Context) error {
+			return errors.New("must not connect")
+		})))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Name:       "users",
+			Database:   "db",
+			Collection: "users",
+			Pipeline:   `[{"$count":"total"}]`,
+			Metrics:    []*Metric{{Name: "users_total", Help: "foobar", Value: "total"}},
+		}))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Kind:       KindCommand,
+			Command:    `{"serverStatus":1}`,
+			ResultPath: "connections",
+			Metrics:    []*Metric{{Name: "connections", Help: "foobar", Value: "current"}},
+		}))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			DatabasePattern:   "tenant_*",
+			CollectionPattern: "users",
+			Pipeline:          `[{"$count":"total"}]`,
+			Metrics:           []*Metric{{Name: "tenant_users_total", Help: "foobar", Value: "total"}},
+		}))
+
+		d := NewFixtureDriver()
+		assert.NoError(t, c.UseDriver("main", d))
+		assert.True(t, c.servers[0].isUp())
+
+		users, err := c.GetAggregation("users")
+		assert.NoError(t, err)
+		assert.NoError(t, d.AddResult(users, "", "", []map[string]interface{}{{"total": 5}}))
+
+		command, err := c.GetAggregation("aggregation_1")
+		assert.NoError(t, err)
+		assert.NoError(t, d.AddResult(command, "", "", []map[string]interface{}{{"connections": map[string]interface{}{"current": 3}}}))
+
+		assert.NoError(t, d.AddResult(nil, "tenant_a", "users", []map[string]interface{}{{"total": 1.5}}))
+
+		_, err = c.GetAggregation("foo")
+		assert.EqualError(t, err, "aggregation foo not found")
+
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP connections foobar
+# TYPE connections gauge
+connections{server="main"} 3
+# HELP tenant_users_total foobar
+# TYPE tenant_users_total gauge
+tenant_users_total{collection="users",database="tenant_a",server="main"} 1.5
+# HELP users_total foobar
+# TYPE users_total gauge
+users_total{server="main"} 5
+`), "users_total", "connections", "tenant_users_total"))
+	})
+
+	t.Run("Commands without fixture fail", func(t *testing.T) {
+		_, err := NewFixtureDriver().RunCommand(context.Background(), "admin", bson.D{{Key: "ping", Value: 1}}, nil)
+		assert.True(t, errors.Is(err, ErrNoFixture))
+	})
+}
+
+func TestExplain(t *testing.T) {
+	explain := func(result AggregationResult) ExplainResult {
+		drv := buildMockDriver(nil)
+		drv.CommandResult = result
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Name:       "users",
+			Database:   "db",
+			Collection: "users",
+			Pipeline:   `[{"$match":{"status":"active"}}]`,
+		}))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Kind:    KindCommand,
+			Command: `{"serverStatus":1}`,
+		}))
+
+		results := c.Explain(context.Background())
+		assert.Len(t, results, 1)
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, "users", results[0].Aggregation)
+		assert.Equal(t, "db", results[0].Database)
+		return results[0]
+	}
+
+	t.Run("Winning plan and execution stats are reported", func(t *testing.T) {
+		result := explain(AggregationResult{
+			"queryPlanner": bson.D{
+				{Key: "winningPlan", Value: bson.D{
+					{Key: "stage", Value: "FETCH"},
+					{Key: "inputStage", Value: bson.D{
+						{Key: "stage", Value: "IXSCAN"},
+						{Key: "indexName", Value: "status_1"},
+					}},
+				}},
+				{Key: "rejectedPlans", Value: bson.A{bson.D{{Key: "stage", Value: "COLLSCAN"}}}},
+			},
+			"executionStats": bson.D{
+				{Key: "nReturned", Value: int32(2)},
+				{Key: "executionTimeMillis", Value: int32(1)},
+				{Key: "totalKeysExamined", Value: int32(3)},
+				{Key: "totalDocsExamined", Value: int32(2)},
+				{Key: "executionStages", Value: bson.D{{Key: "stage", Value: "FETCH"}}},
+			},
+		})
+
+		assert.Equal(t, []string{"FETCH", "IXSCAN"}, result.Stages)
+		assert.Equal(t, []string{"status_1"}, result.Indexes)
+		assert.False(t, result.CollectionScan)
+		assert.Equal(t, int64(2), result.DocsExamined)
+		assert.Equal(t, int64(3), result.KeysExamined)
+		assert.Equal(t, int64(2), result.Returned)
+		assert.Equal(t, time.Millisecond, result.Duration)
+	})
+
+	t.Run("Explain is limited by the aggregation timeout and uses its read options", func(t *testing.T) {
+		drv := buildMockDriver(nil)
+		drv.CommandResult = AggregationResult{}
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Pipeline:       "[]",
+			Timeout:        2 * time.Second,
+			ReadPreference: "secondaryPreferred",
+			ReadConcern:    "majority",
+		}))
+
+		results := c.Explain(context.Background())
+		assert.Len(t, results, 1)
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, 2*time.Second, drv.QueryOptions.MaxTime)
+		assert.Equal(t, "secondaryPreferred", drv.QueryOptions.ReadPreference.Mode().String())
+		assert.Equal(t, "majority", drv.QueryOptions.ReadConcern.Level)
+	})
+
+	t.Run("Collection scans within a $cursor stage are detected", func(t *testing.T) {
+		result := explain(AggregationResult{
+			"stages": bson.A{
+				bson.D{{Key: "$cursor", Value: bson.D{
+					{Key: "queryPlanner", Value: bson.D{
+						{Key: "winningPlan", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}},
+					}},
+					{Key: "executionStats", Value: bson.D{
+						{Key: "totalDocsExamined", Value: int64(1000)},
+					}},
+				}}},
+				bson.D{{Key: "$group", Value: bson.D{}}},
+			},
+		})
+
+		assert.Equal(t, []string{"COLLSCAN"}, result.Stages)
+		assert.True(t, result.CollectionScan)
+		assert.Equal(t, int64(1000), result.DocsExamined)
+	})
+
+	t.Run("Background check updates the explain gauges", func(t *testing.T) {
+		drv := buildMockDriver(nil)
+		drv.CommandResult = AggregationResult{
+			"queryPlanner":   bson.D{{Key: "winningPlan", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}}},
+			"executionStats": bson.D{{Key: "totalDocsExamined", Value: int32(7)}},
+		}
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Database:   "db",
+			Collection: "users",
+			Pipeline:   "[]",
+		}))
+
+		labels := []string{"aggregation", "server", "database", "collection"}
+		m := ExplainMetrics{
+			DocsExamined:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "docs"}, labels),
+			KeysExamined:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "keys"}, labels),
+			CollectionScan: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "collscan"}, labels),
+		}
+
+		assert.NoError(t, c.StartExplainer(10*time.Millisecond, m))
+		defer c.cancel()
+
+		assert.Eventually(t, func() bool {
+			return testutil.CollectAndCount(m.CollectionScan) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(m.CollectionScan.WithLabelValues("aggregation_0", "main", "db", "users")))
+		assert.Equal(t, float64(7), testutil.ToFloat64(m.DocsExamined.WithLabelValues("aggregation_0", "main", "db", "users")))
+	})
+
+	t.Run("Background check skips servers which never connect", func(t *testing.T) {
+		drv := buildMockDriver(nil)
+		drv.CommandResult = AggregationResult{
+			"queryPlanner": bson.D{{Key: "winningPlan", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}}},
+		}
+
+		c := New()
+		assert.NoError(t, c.RegisterServer("down", buildMockDriver(nil), WithConnect(func(ctx context.Context) error {
+			return errors.New("unreachable")
+		})))
+		assert.NoError(t, c.RegisterServer("main", drv))
+		assert.NoError(t, c.RegisterAggregation(&Aggregation{
+			Database:   "db",
+			Collection: "users",
+			Pipeline:   "[]",
+		}))
+
+		labels := []string{"aggregation", "server", "database", "collection"}
+		m := ExplainMetrics{
+			CollectionScan: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "collscan"}, labels),
+		}
+
+		assert.NoError(t, c.StartExplainer(10*time.Millisecond, m))
+		defer c.cancel()
+
+		assert.Eventually(t, func() bool {
+			return testutil.CollectAndCount(m.CollectionScan) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(m.CollectionScan.WithLabelValues("aggregation_0", "main", "db", "users")))
+	})
+}
+
+func duration(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestDefaults(t *testing.T) {
+	c := New(WithConfig(&Config{
+		QueryTimeout:      time.Second,
+		DefaultCache:      time.Minute,
+		DefaultMode:       ModePull,
+		DefaultDatabase:   "db",
+		DefaultCollection: "col",
+	}))
+	assert.NoError(t, c.RegisterServer("main", buildMockDriver(nil)))
+
+	t.Run("Global defaults are applied to unset settings", func(t *testing.T) {
+		aggregation := &Aggregation{Pipeline: "[]"}
+		assert.NoError(t, c.RegisterAggregation(aggregation))
+		assert.Equal(t, KindAggregate, aggregation.Kind)
+		assert.Equal(t, ModePull, aggregation.Mode)
+		assert.Equal(t, "db", aggregation.Database)
+		assert.Equal(t, "col", aggregation.Collection)
+		assert.Equal(t, duration(time.Minute), aggregation.Cache)
+	})
+
+	t.Run("Aggregation settings take precedence", func(t *testing.T) {
+		aggregation := &Aggregation{Pipeline: "[]", Database: "foo", Collection: "bar", Cache: duration(time.Second)}
+		assert.NoError(t, c.RegisterAggregation(aggregation))
+		assert.Equal(t, "foo", aggregation.Database)
+		assert.Equal(t, "bar", aggregation.Collection)
+		assert.Equal(t, duration(time.Second), aggregation.Cache)
+	})
+
+	t.Run("Patterns are not overridden by the default namespace", func(t *testing.T) {
+		aggregation := &Aggregation{Pipeline: "[]", DatabasePattern: "foo*", CollectionPattern: "bar*"}
+		assert.NoError(t, c.RegisterAggregation(aggregation))
+		assert.Equal(t, "", aggregation.Database)
+		assert.Equal(t, "", aggregation.Collection)
+	})
+
+	t.Run("Commands are executed on the admin database", func(t *testing.T) {
+		aggregation := &Aggregation{Kind: KindCommand, Command: `{"ping": 1}`}
+		assert.NoError(t, c.RegisterAggregation(aggregation))
+		assert.Equal(t, DefaultCommandDatabase, aggregation.Database)
+		assert.Equal(t, "", aggregation.Collection)
+	})
+
+	t.Run("Push aggregations are not cached by the default cache", func(t *testing.T) {
+		aggregation := &Aggregation{Pipeline: "[]", Mode: ModePush}
+		assert.NoError(t, c.RegisterAggregation(aggregation))
+		assert.Nil(t, aggregation.Cache)
+	})
+
+	t.Run("Aggregations opt out of the default cache with a cache of 0", func(t *testing.T) {
+		aggregation := &Aggregation{Pipeline: "[]", Cache: duration(0)}
+		assert.NoError(t, c.RegisterAggregation(aggregation))
+		assert.Equal(t, duration(0), aggregation.Cache)
+	})
+
+	t.Run("Unknown mode fails", func(t *testing.T) {
+		assert.EqualError(t, c.RegisterAggregation(&Aggregation{Pipeline: "[]", Mode: "poll"}), "unknown aggregation mode poll provided. Only [pull, push] are valid options")
+	})
+
+	t.Run("Effective config contains the applied defaults", func(t *testing.T) {
+		effective := c.EffectiveConfig()
+		assert.Equal(t, "1s", effective.QueryTimeout)
+		assert.Equal(t, []string{"main"}, effective.Servers)
+		assert.Len(t, effective.Aggregations, 6)
+		assert.Equal(t, EffectiveAggregation{
+			Name:       "aggregation_0",
+			Servers:    []string{"main"},
+			Kind:       KindAggregate,
+			Mode:       ModePull,
+			Database:   "db",
+			Collection: "col",
+			Cache:      "1m0s",
+			Timeout:    "1s",
+			Metrics:    []string{},
+		}, effective.Aggregations[0])
+		assert.Equal(t, "until change", effective.Aggregations[4].Cache)
+		assert.Equal(t, "disabled", effective.Aggregations[5].Cache)
+	})
 }
@@ -0,0 +1,76 @@
+package collector
+
+// The settings the collector applies, including the resolved global defaults
+type EffectiveConfig struct {
+	QueryTimeout string                 `json:"queryTimeout"`
+	Servers      []string               `json:"servers"`
+	Aggregations []EffectiveAggregation `json:"aggregations"`
+}
+
+// The settings applied to an aggregation after resolving the global defaults
+type EffectiveAggregation struct {
+	Name              string   `json:"name"`
+	Group             string   `json:"group,omitempty"`
+	Servers           []string `json:"servers"`
+	Kind              string   `json:"kind"`
+	Mode              string   `json:"mode"`
+	Database          string   `json:"database,omitempty"`
+	Collection        string   `json:"collection,omitempty"`
+	DatabasePattern   string   `json:"databasePattern,omitempty"`
+	CollectionPattern string   `json:"collectionPattern,omitempty"`
+	Cache             string   `json:"cache"`
+	Timeout           string   `json:"timeout"`
+	Metrics           []string `json:"metrics"`
+}
+
+// Return the settings of the registered servers and aggregations after the global defaults have been applied
+func (c *Collector) EffectiveConfig() EffectiveConfig {
+	effective := EffectiveConfig{
+		QueryTimeout: c.config.QueryTimeout.String(),
+		Servers:      []string{},
+		Aggregations: []EffectiveAggregation{},
+	}
+
+	for _, srv := range c.servers {
+		effective.Servers = append(effective.Servers, srv.name)
+	}
+
+	for i, aggregation := range c.aggregations {
+		e := EffectiveAggregation{
+			Name:              aggregation.label(i),
+			Group:             aggregation.Group,
+			Servers:           []string{},
+			Kind:              aggregation.Kind,
+			Mode:              aggregation.Mode,
+			Database:          aggregation.Database,
+			Collection:        aggregation.Collection,
+			DatabasePattern:   aggregation.DatabasePattern,
+			CollectionPattern: aggregation.CollectionPattern,
+			Cache:             aggregation.cache().String(),
+			Timeout:           c.timeout(aggregation).String(),
+			Metrics:           []string{},
+		}
+
+		// See updateCache
+		switch cache := aggregation.cache(); {
+		case aggregation.Mode == ModePush && (cache == 0 || cache == -1):
+			e.Cache = "until change"
+		case cache == -1:
+			e.Cache = "forever"
+		case cache == 0:
+			e.Cache = "disabled"
+		}
+
+		for _, srv := range c.GetServers(aggregation.Servers) {
+			e.Servers = append(e.Servers, srv.name)
+		}
+
+		for _, metric := range aggregation.Metrics {
+			e.Metrics = append(e.Metrics, metric.Name)
+		}
+
+		effective.Aggregations = append(effective.Aggregations, e)
+	}
+
+	return effective
+}
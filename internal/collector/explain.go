@@ -0,0 +1,272 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The winning plan and execution stats of an aggregation on a server and namespace
+type ExplainResult struct {
+	Aggregation    string
+	Server         string
+	Database       string
+	Collection     string
+	Stages         []string
+	Indexes        []string
+	CollectionScan bool
+	DocsExamined   int64
+	KeysExamined   int64
+	Returned       int64
+	Duration       time.Duration
+	Err            error
+}
+
+// Gauges updated by the background explain check, labeled by aggregation, server, database and collection
+type ExplainMetrics struct {
+	DocsExamined   *prometheus.GaugeVec
+	KeysExamined   *prometheus.GaugeVec
+	CollectionScan *prometheus.GaugeVec
+}
+
+// Explain the aggregations matching the filters on each of their servers (with executionStats verbosity).
+// Servers which are not connected yet are awaited until the context is done.
+// Database commands can not be explained and are skipped.
+func (c *Collector) Explain(ctx context.Context, filters ...Filter) []ExplainResult {
+	return c.explainAll(ctx, true, filters)
+}
+
+// Explain the aggregations matching the filters, servers which are down are either awaited or skipped
+func (c *Collector) explainAll(ctx context.Context, wait bool, filters []Filter) []ExplainResult {
+	s := scope{filters: filters}
+	var results []ExplainResult
+
+	for i, aggregation := range c.aggregations {
+		if !s.matchAggregation(aggregation, i) || aggregation.Kind == KindCommand {
+			continue
+		}
+
+		for _, srv := range c.GetServers(aggregation.Servers) {
+			result := ExplainResult{
+				Aggregation: aggregation.label(i),
+				Server:      srv.name,
+			}
+
+			if !wait && !srv.isUp() {
+				result.Err = ErrNotConnected
+				results = append(results, result)
+				continue
+			}
+
+			select {
+			case <-srv.ready:
+			case <-ctx.Done():
+				result.Err = ErrNotConnected
+				results = append(results, result)
+				continue
+			}
+
+			namespaces, err := c.resolveNamespaces(ctx, aggregation, srv)
+			if err != nil {
+				result.Err = err
+				results = append(results, result)
+				continue
+			}
+
+			for _, ns := range namespaces {
+				result := result
+				result.Database, result.Collection = ns.database, ns.collection
+				result.Err = c.explain(ctx, aggregation, srv, ns, &result)
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results
+}
+
+func (c *Collector) explain(ctx context.Context, aggregation *Aggregation, srv *server, ns namespace, result *ExplainResult) error {
+	timeout := c.timeout(aggregation)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+maxTimeGrace)
+	defer cancel()
+
+	// An explain with executionStats runs the whole pipeline, the timeout is propagated to the server (maxTimeMS) like for the aggregation itself
+	opts := *aggregation.queryOptions
+	opts.MaxTime = timeout
+
+	command := bson.D{
+		{Key: "aggregate", Value: ns.collection},
+		{Key: "pipeline", Value: aggregation.pipeline},
+		{Key: "cursor", Value: bson.D{}},
+	}
+
+	// Options which influence the query plan
+	if opts.Hint != nil {
+		command = append(command, bson.E{Key: "hint", Value: opts.Hint})
+	}
+
+	if opts.AllowDiskUse {
+		command = append(command, bson.E{Key: "allowDiskUse", Value: true})
+	}
+
+	if opts.Collation != nil {
+		command = append(command, bson.E{Key: "collation", Value: bson.Raw(opts.Collation.ToDocument())})
+	}
+
+	explain, err := srv.driver.RunCommand(ctx, ns.database, bson.D{
+		{Key: "explain", Value: command},
+		{Key: "verbosity", Value: "executionStats"},
+	}, &opts)
+
+	if err != nil {
+		return fmt.Errorf("failed to explain aggregation: %w", err)
+	}
+
+	result.walk(explain, false)
+	for _, stage := range result.Stages {
+		if stage == "COLLSCAN" {
+			result.CollectionScan = true
+		}
+	}
+
+	return nil
+}
+
+// Collect the stages of the winning plans and sum up the execution stats.
+// The explain output differs between MongoDB versions and topologies (like aggregations
+// with a $cursor stage or sharded clusters), plans and stats are therefore searched recursively.
+func (result *ExplainResult) walk(val interface{}, winningPlan bool) {
+	if doc, ok := toAggregationResult(val); ok {
+		if stage, ok := doc["stage"].(string); ok && winningPlan {
+			result.Stages = append(result.Stages, stage)
+		}
+
+		if index, ok := doc["indexName"].(string); ok && winningPlan {
+			result.Indexes = append(result.Indexes, index)
+		}
+
+		// Stages are collected top down in a stable order
+		keys := make([]string, 0, len(doc))
+		for key := range doc {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			v := doc[key]
+			switch key {
+			case "stage", "indexName", "rejectedPlans", "allPlansExecution":
+			case "winningPlan":
+				result.walk(v, true)
+			case "executionStats":
+				if stats, ok := toAggregationResult(v); ok {
+					result.DocsExamined += toInt64(stats["totalDocsExamined"])
+					result.KeysExamined += toInt64(stats["totalKeysExamined"])
+					result.Returned += toInt64(stats["nReturned"])
+					result.Duration += time.Duration(toInt64(stats["executionTimeMillis"])) * time.Millisecond
+				}
+			default:
+				result.walk(v, winningPlan)
+			}
+		}
+
+		return
+	}
+
+	if list, ok := val.(bson.A); ok {
+		for _, v := range list {
+			result.walk(v, winningPlan)
+		}
+	}
+}
+
+func toInt64(val interface{}) int64 {
+	switch v := val.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+// Periodically explain all aggregations and update the explain gauges.
+// Servers which are down are skipped and each check has to finish within the interval.
+// This is a non blocking operation.
+func (c *Collector) StartExplainer(interval time.Duration, m ExplainMetrics) error {
+	c.tasks.Add(1)
+	go func() {
+		defer c.tasks.Done()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			ctx, cancel := context.WithTimeout(c.ctx, interval)
+			results := c.explainAll(ctx, false, nil)
+			cancel()
+
+			// A stopped collector must not override the gauges of its successor
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			m.update(results)
+		}
+	}()
+
+	return nil
+}
+
+func (m ExplainMetrics) update(results []ExplainResult) {
+	for _, vec := range []*prometheus.GaugeVec{m.DocsExamined, m.KeysExamined, m.CollectionScan} {
+		if vec != nil {
+			vec.Reset()
+		}
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+
+		labels := prometheus.Labels{
+			"aggregation": result.Aggregation,
+			"server":      result.Server,
+			"database":    result.Database,
+			"collection":  result.Collection,
+		}
+
+		if m.DocsExamined != nil {
+			m.DocsExamined.With(labels).Set(float64(result.DocsExamined))
+		}
+
+		if m.KeysExamined != nil {
+			m.KeysExamined.With(labels).Set(float64(result.KeysExamined))
+		}
+
+		if m.CollectionScan != nil {
+			var scan float64
+			if result.CollectionScan {
+				scan = 1
+			}
+
+			m.CollectionScan.With(labels).Set(scan)
+		}
+	}
+}
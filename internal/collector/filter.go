@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Filter restricts which aggregations are collected during a scrape.
+// An aggregation matches if its name (aggregation_<index> if unnamed) and group are listed, empty lists match all aggregations.
+type Filter struct {
+	Aggregations []string
+	Groups       []string
+}
+
+// Restricts which aggregations are executed on which servers during a scrape
+type scope struct {
+	// Execute the aggregations only on this server
+	target *server
+	// Only execute aggregations matching all filters
+	filters []Filter
+}
+
+// Returns a collector which only collects the aggregations matching all filters until the given context is done
+func (c *Collector) WithFilter(ctx context.Context, filters ...Filter) prometheus.Collector {
+	return &contextCollector{c, ctx, scope{filters: filters}}
+}
+
+// Reports whether the aggregation at the given index matches the filter
+func (f Filter) match(aggregation *Aggregation, i int) bool {
+	return contains(f.Aggregations, aggregation.label(i)) && contains(f.Groups, aggregation.Group)
+}
+
+// Reports whether the aggregation at the given index is executed within the scope
+func (s scope) matchAggregation(aggregation *Aggregation, i int) bool {
+	for _, f := range s.filters {
+		if !f.match(aggregation, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The label of an aggregation used in logs and the query counter, either its name or its position
+func (aggregation *Aggregation) label(i int) string {
+	if aggregation.Name != "" {
+		return aggregation.Name
+	}
+
+	return fmt.Sprintf("aggregation_%d", i)
+}
+
+// Reports whether a list contains a value, an empty list contains any value
+func contains(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// No fixture has been added for a database command
+var ErrNoFixture = errors.New("no fixture found")
+
+// A driver returning fixture documents instead of querying MongoDB, used to test aggregations offline.
+// The pipeline is not executed, the documents are returned as they would be returned by the pipeline.
+type FixtureDriver struct {
+	results map[fixtureKey][]AggregationResult
+	mutex   sync.Mutex
+}
+
+// Fixtures are bound to a namespace and optionally to a pipeline or command
+type fixtureKey struct {
+	database   string
+	collection string
+	query      string
+}
+
+// Create a driver without any fixtures
+func NewFixtureDriver() *FixtureDriver {
+	return &FixtureDriver{
+		results: make(map[fixtureKey][]AggregationResult),
+	}
+}
+
+// Add documents returned by the aggregation on the given namespace.
+// The namespace of the aggregation is used if no database and collection are given.
+// If no aggregation is given the documents are returned for all aggregations on the namespace.
+// Documents are converted to BSON types like they would be returned by MongoDB.
+func (d *FixtureDriver) AddResult(aggregation *Aggregation, database, collection string, docs []map[string]interface{}) error {
+	key := fixtureKey{database: database, collection: collection}
+	if aggregation != nil {
+		if database == "" && collection == "" {
+			key.database, key.collection = aggregation.Database, aggregation.Collection
+		}
+
+		if aggregation.Kind == KindCommand {
+			key.collection = ""
+			key.query = fixtureQuery(aggregation.command)
+		} else {
+			key.query = fixtureQuery(aggregation.pipeline)
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, doc := range docs {
+		b, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+
+		var result AggregationResult
+		if err := bson.Unmarshal(b, &result); err != nil {
+			return err
+		}
+
+		d.results[key] = append(d.results[key], result)
+	}
+
+	if _, ok := d.results[key]; !ok {
+		d.results[key] = []AggregationResult{}
+	}
+
+	return nil
+}
+
+func fixtureQuery(query interface{}) string {
+	b, _ := bson.MarshalExtJSON(bson.D{{Key: "query", Value: query}}, true, false)
+	return string(b)
+}
+
+// Lookup the fixture for the query, fixtures for the whole namespace are used as fallback
+func (d *FixtureDriver) lookup(database, collection string, query interface{}) ([]AggregationResult, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if results, ok := d.results[fixtureKey{database, collection, fixtureQuery(query)}]; ok {
+		return results, true
+	}
+
+	results, ok := d.results[fixtureKey{database: database, collection: collection}]
+	return results, ok
+}
+
+func (d *FixtureDriver) Connect(ctx context.Context, opts ...*options.ClientOptions) error {
+	return nil
+}
+
+func (d *FixtureDriver) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func (d *FixtureDriver) Ping(ctx context.Context, rp *readpref.ReadPref) error {
+	return nil
+}
+
+// Returns the fixture documents, no documents are returned if there is no fixture for the namespace
+func (d *FixtureDriver) Aggregate(ctx context.Context, db string, col string, pipeline bson.A, opts *QueryOptions) (Cursor, error) {
+	results, _ := d.lookup(db, col, pipeline)
+	return &resultCursor{results: append([]AggregationResult{}, results...)}, nil
+}
+
+// Returns the first fixture document as command result
+func (d *FixtureDriver) RunCommand(ctx context.Context, db string, command bson.D, opts *QueryOptions) (AggregationResult, error) {
+	results, ok := d.lookup(db, "", command)
+	if !ok || len(results) == 0 {
+		return nil, ErrNoFixture
+	}
+
+	return results[0], nil
+}
+
+// Returns the databases of all fixtures
+func (d *FixtureDriver) ListDatabaseNames(ctx context.Context, opts *QueryOptions) ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.names(func(key fixtureKey) (string, bool) {
+		return key.database, key.database != ""
+	}), nil
+}
+
+// Returns the collections of all fixtures within the database
+func (d *FixtureDriver) ListCollectionNames(ctx context.Context, db string, opts *QueryOptions) ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.names(func(key fixtureKey) (string, bool) {
+		return key.collection, key.database == db && key.collection != ""
+	}), nil
+}
+
+func (d *FixtureDriver) names(name func(key fixtureKey) (string, bool)) []string {
+	unique := make(map[string]bool)
+	for key := range d.results {
+		if n, ok := name(key); ok {
+			unique[n] = true
+		}
+	}
+
+	names := []string{}
+	for n := range unique {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Change streams are not supported, aggregations in push mode are executed like in pull mode
+func (d *FixtureDriver) Watch(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error) {
+	return nil, errors.New("change streams are not supported by fixtures")
+}
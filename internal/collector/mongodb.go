@@ -2,6 +2,9 @@ package collector
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,6 +17,7 @@ type Cursor interface {
 	Next(ctx context.Context) bool
 	Close(ctx context.Context) error
 	Decode(val interface{}) error
+	Err() error
 }
 
 // MongoDB event stream
@@ -30,41 +34,241 @@ type ChangeStreamEvent struct {
 // MongoDB aggregation result
 type AggregationResult map[string]interface{}
 
+// Lookup a value by its key, if the key does not exist the key is used as a dot separated path
+// to look up a value from embedded documents
+func (result AggregationResult) lookup(path string) (interface{}, bool) {
+	if val, ok := result[path]; ok {
+		return val, true
+	}
+
+	var current interface{} = result
+	for _, key := range strings.Split(path, ".") {
+		doc, ok := toAggregationResult(current)
+		if !ok {
+			return nil, false
+		}
+
+		if current, ok = doc[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Convert an embedded document into an aggregation result
+func toAggregationResult(val interface{}) (AggregationResult, bool) {
+	switch v := val.(type) {
+	case AggregationResult:
+		return v, true
+	case map[string]interface{}:
+		return AggregationResult(v), true
+	case bson.M:
+		return AggregationResult(v), true
+	case bson.D:
+		result := make(AggregationResult, len(v))
+		for _, elem := range v {
+			result[elem.Key] = elem.Value
+		}
+
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// A cursor over results which are already fetched, for example from a database command
+type resultCursor struct {
+	results []AggregationResult
+	current AggregationResult
+}
+
+func (cursor *resultCursor) Next(ctx context.Context) bool {
+	if len(cursor.results) == 0 {
+		return false
+	}
+
+	cursor.current, cursor.results = cursor.results[0], cursor.results[1:]
+	return true
+}
+
+func (cursor *resultCursor) Close(ctx context.Context) error {
+	return nil
+}
+
+func (cursor *resultCursor) Err() error {
+	return nil
+}
+
+func (cursor *resultCursor) Decode(val interface{}) error {
+	result, ok := val.(*AggregationResult)
+	if !ok {
+		return fmt.Errorf("can not decode command result into %T", val)
+	}
+
+	*result = cursor.current
+	return nil
+}
+
 // MongoDB driver abstraction
 type Driver interface {
 	Connect(ctx context.Context, opts ...*options.ClientOptions) error
 	Ping(ctx context.Context, rp *readpref.ReadPref) error
-	Aggregate(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error)
+	Aggregate(ctx context.Context, db string, col string, pipeline bson.A, opts *QueryOptions) (Cursor, error)
+	RunCommand(ctx context.Context, db string, command bson.D, opts *QueryOptions) (AggregationResult, error)
+	ListDatabaseNames(ctx context.Context, opts *QueryOptions) ([]string, error)
+	ListCollectionNames(ctx context.Context, db string, opts *QueryOptions) ([]string, error)
 	Watch(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error)
+	Disconnect(ctx context.Context) error
 }
 
 // MongoDB driver
 type MongoDBDriver struct {
 	client *mongo.Client
+	mutex  sync.RWMutex
 }
 
-// Connect to the server
+// Connect to the server.
+// An existing connection is replaced (like after rotating credentials) and gets disconnected once the new one is in place.
 func (mdb *MongoDBDriver) Connect(ctx context.Context, opts ...*options.ClientOptions) error {
 	client, err := mongo.Connect(ctx, opts...)
 	if err != nil {
 		return err
 	}
 
+	mdb.mutex.Lock()
+	previous := mdb.client
 	mdb.client = client
-	return nil
+	mdb.mutex.Unlock()
+
+	if previous == nil {
+		return nil
+	}
+
+	return previous.Disconnect(ctx)
+}
+
+// Disconnect from the server
+func (mdb *MongoDBDriver) Disconnect(ctx context.Context) error {
+	mdb.mutex.Lock()
+	client := mdb.client
+	mdb.client = nil
+	mdb.mutex.Unlock()
+
+	if client == nil {
+		return nil
+	}
+
+	return client.Disconnect(ctx)
+}
+
+func (mdb *MongoDBDriver) getClient() (*mongo.Client, error) {
+	mdb.mutex.RLock()
+	defer mdb.mutex.RUnlock()
+
+	if mdb.client == nil {
+		return nil, ErrNotConnected
+	}
+
+	return mdb.client, nil
 }
 
 // Enforce connection to the server
 func (mdb *MongoDBDriver) Ping(ctx context.Context, rp *readpref.ReadPref) error {
-	return mdb.client.Ping(ctx, rp)
+	client, err := mdb.getClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Ping(ctx, rp)
 }
 
 // Aggregation rquery
-func (mdb *MongoDBDriver) Aggregate(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error) {
-	return mdb.client.Database(db).Collection(col).Aggregate(ctx, pipeline)
+func (mdb *MongoDBDriver) Aggregate(ctx context.Context, db string, col string, pipeline bson.A, opts *QueryOptions) (Cursor, error) {
+	client, err := mdb.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Database(db).Collection(col, opts.collectionOptions()).Aggregate(ctx, pipeline, opts.aggregateOptions())
+}
+
+// Run a database command
+func (mdb *MongoDBDriver) RunCommand(ctx context.Context, db string, command bson.D, opts *QueryOptions) (AggregationResult, error) {
+	client, err := mdb.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var result AggregationResult
+	err = client.Database(db).RunCommand(ctx, opts.command(command), opts.runCmdOptions()).Decode(&result)
+	return result, err
+}
+
+// List the names of all databases.
+// The command is run directly as the list options of the driver do not support a time limit (maxTimeMS).
+func (mdb *MongoDBDriver) ListDatabaseNames(ctx context.Context, opts *QueryOptions) ([]string, error) {
+	client, err := mdb.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Databases []struct {
+			Name string `bson:"name"`
+		} `bson:"databases"`
+	}
+
+	err = client.Database("admin").RunCommand(ctx, opts.command(bson.D{
+		{Key: "listDatabases", Value: 1},
+		{Key: "nameOnly", Value: true},
+	})).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(result.Databases))
+	for _, db := range result.Databases {
+		names = append(names, db.Name)
+	}
+
+	return names, nil
+}
+
+// List the names of all collections within a database.
+// The command is run directly as the list options of the driver do not support a time limit (maxTimeMS).
+func (mdb *MongoDBDriver) ListCollectionNames(ctx context.Context, db string, opts *QueryOptions) ([]string, error) {
+	client, err := mdb.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := client.Database(db).RunCommandCursor(ctx, opts.command(bson.D{
+		{Key: "listCollections", Value: 1},
+		{Key: "nameOnly", Value: true},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close(ctx)
+
+	var names []string
+	for cursor.Next(ctx) {
+		if name, ok := cursor.Current.Lookup("name").StringValueOK(); ok {
+			names = append(names, name)
+		}
+	}
+
+	return names, cursor.Err()
 }
 
 // Start an eventstream
 func (mdb *MongoDBDriver) Watch(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error) {
-	return mdb.client.Database(db).Collection(col).Watch(ctx, pipeline)
+	client, err := mdb.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Database(db).Collection(col).Watch(ctx, pipeline)
 }
@@ -2,6 +2,8 @@ package collector
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -11,12 +13,25 @@ import (
 type mockMongoDBDriver struct {
 	ChangeStreamData *mockCursor
 	AggregateCursor  *mockCursor
+	CommandResult    AggregationResult
+	QueryOptions     *QueryOptions
+	ListOptions      *QueryOptions
+	Error            error
+	WatchError       error
+	Delay            time.Duration
+	ListDelay        time.Duration
+	Databases        []string
+	Collections      map[string][]string
+	Disconnected     bool
+	mutex            sync.Mutex
 }
 
 type mockCursor struct {
-	Data    []interface{}
-	cursor  []interface{}
-	Current interface{}
+	Data     []interface{}
+	cursor   []interface{}
+	Current  interface{}
+	Blocking bool
+	Closed   bool
 }
 
 func (cursor *mockCursor) Decode(val interface{}) error {
@@ -31,6 +46,11 @@ func (cursor *mockCursor) Decode(val interface{}) error {
 
 func (cursor *mockCursor) Next(ctx context.Context) bool {
 	if len(cursor.cursor) == 0 {
+		// Like a change stream which waits for new events
+		if cursor.Blocking {
+			<-ctx.Done()
+		}
+
 		return false
 	}
 
@@ -38,7 +58,12 @@ func (cursor *mockCursor) Next(ctx context.Context) bool {
 	return true
 }
 
+func (cursor *mockCursor) Err() error {
+	return nil
+}
+
 func (cursor *mockCursor) Close(ctx context.Context) error {
+	cursor.Closed = true
 	return nil
 }
 
@@ -46,17 +71,75 @@ func (mdb *mockMongoDBDriver) Connect(ctx context.Context, opts ...*options.Clie
 	return nil
 }
 
+func (mdb *mockMongoDBDriver) Disconnect(ctx context.Context) error {
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+	mdb.Disconnected = true
+	return nil
+}
+
 func (mdb *mockMongoDBDriver) Ping(ctx context.Context, rp *readpref.ReadPref) error {
 	return nil
 }
 
-func (mdb *mockMongoDBDriver) Aggregate(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error) {
-	// reset cursor
-	mdb.AggregateCursor.cursor = mdb.AggregateCursor.Data
+func (mdb *mockMongoDBDriver) Aggregate(ctx context.Context, db string, col string, pipeline bson.A, opts *QueryOptions) (Cursor, error) {
+	mdb.mutex.Lock()
+	mdb.QueryOptions = opts
+	mdb.mutex.Unlock()
 
-	return mdb.AggregateCursor, nil
+	if mdb.Error != nil {
+		return nil, mdb.Error
+	}
+
+	select {
+	case <-time.After(mdb.Delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// each aggregation gets its own cursor over the same data
+	return &mockCursor{
+		Data:   mdb.AggregateCursor.Data,
+		cursor: mdb.AggregateCursor.Data,
+	}, nil
+}
+
+func (mdb *mockMongoDBDriver) RunCommand(ctx context.Context, db string, command bson.D, opts *QueryOptions) (AggregationResult, error) {
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	mdb.QueryOptions = opts
+	return mdb.CommandResult, nil
+}
+
+func (mdb *mockMongoDBDriver) ListDatabaseNames(ctx context.Context, opts *QueryOptions) ([]string, error) {
+	mdb.ListOptions = opts
+
+	select {
+	case <-time.After(mdb.ListDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return mdb.Databases, nil
+}
+
+func (mdb *mockMongoDBDriver) ListCollectionNames(ctx context.Context, db string, opts *QueryOptions) ([]string, error) {
+	mdb.ListOptions = opts
+	return mdb.Collections[db], nil
 }
 
 func (mdb *mockMongoDBDriver) Watch(ctx context.Context, db string, col string, pipeline bson.A) (Cursor, error) {
+	mdb.mutex.Lock()
+	defer mdb.mutex.Unlock()
+
+	if mdb.WatchError != nil {
+		return nil, mdb.WatchError
+	}
+
+	if mdb.ChangeStreamData != nil {
+		return mdb.ChangeStreamData, nil
+	}
+
 	return mdb.AggregateCursor, nil
 }
@@ -0,0 +1,213 @@
+package collector
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A namespace (database and collection) an aggregation is executed on
+type namespace struct {
+	database   string
+	collection string
+}
+
+// Discovered namespaces are stored per aggregation and server
+type namespaceKey struct {
+	aggregation *Aggregation
+	server      string
+}
+
+// Discovered namespaces which are valid until they expire
+type namespaceEntry struct {
+	namespaces []namespace
+	expires    time.Time
+}
+
+// A matcher reports whether a database or collection name matches a pattern
+type matcher func(name string) bool
+
+// Compile a pattern into a matcher.
+// A pattern wrapped in slashes (like /^tenant_.*$/) is a regular expression, otherwise it is used as glob (like tenant_*).
+func compilePattern(pattern string) (matcher, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	return func(name string) bool {
+		match, _ := path.Match(pattern, name)
+		return match
+	}, nil
+}
+
+// Compile the database and collection patterns of an aggregation
+func (c *Collector) compilePatterns(aggregation *Aggregation) error {
+	if aggregation.DatabasePattern == "" && aggregation.CollectionPattern == "" {
+		return nil
+	}
+
+	if aggregation.Mode == ModePush {
+		return errors.New("database and collection patterns are not supported in push mode")
+	}
+
+	if aggregation.Kind == KindCommand && aggregation.CollectionPattern != "" {
+		return errors.New("collection patterns are not supported for database commands")
+	}
+
+	if aggregation.DatabasePattern != "" {
+		m, err := compilePattern(aggregation.DatabasePattern)
+		if err != nil {
+			return errors.Wrap(err, "failed to compile database pattern")
+		}
+
+		aggregation.matchDatabase = m
+	}
+
+	if aggregation.CollectionPattern != "" {
+		m, err := compilePattern(aggregation.CollectionPattern)
+		if err != nil {
+			return errors.Wrap(err, "failed to compile collection pattern")
+		}
+
+		aggregation.matchCollection = m
+	}
+
+	if aggregation.DiscoveryInterval == 0 {
+		aggregation.DiscoveryInterval = DefaultDiscoveryInterval
+	}
+
+	return nil
+}
+
+// The namespace configured for an aggregation
+func (aggregation *Aggregation) namespace() namespace {
+	return namespace{
+		database:   aggregation.Database,
+		collection: aggregation.Collection,
+	}
+}
+
+// Names of the labels every metric of an aggregation has
+func (aggregation *Aggregation) labelNames() []string {
+	labels := []string{"server"}
+	if aggregation.matchDatabase != nil {
+		labels = append(labels, "database")
+	}
+
+	if aggregation.matchCollection != nil {
+		labels = append(labels, "collection")
+	}
+
+	return labels
+}
+
+// Values of the labels every metric of an aggregation has
+func (aggregation *Aggregation) labelValues(srv *server, ns namespace) []string {
+	labels := []string{srv.name}
+	if aggregation.matchDatabase != nil {
+		labels = append(labels, ns.database)
+	}
+
+	if aggregation.matchCollection != nil {
+		labels = append(labels, ns.collection)
+	}
+
+	return labels
+}
+
+// Return all namespaces an aggregation is executed on.
+// Databases and collections matching a pattern are discovered from the server and
+// cached until the discovery interval is reached.
+func (c *Collector) resolveNamespaces(ctx context.Context, aggregation *Aggregation, srv *server) ([]namespace, error) {
+	if aggregation.matchDatabase == nil && aggregation.matchCollection == nil {
+		return []namespace{aggregation.namespace()}, nil
+	}
+
+	// Namespaces of ad-hoc targets are discovered per probe
+	if srv.adhoc {
+		return c.discoverNamespaces(ctx, aggregation, srv)
+	}
+
+	key := namespaceKey{aggregation, srv.name}
+
+	c.mutex.Lock()
+	e, exists := c.namespaces[key]
+	c.mutex.Unlock()
+
+	if exists && e.expires.After(time.Now()) {
+		return e.namespaces, nil
+	}
+
+	namespaces, err := c.discoverNamespaces(ctx, aggregation, srv)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger.Debugf("discovered %d namespaces on server %s", len(namespaces), srv.name)
+
+	c.mutex.Lock()
+	c.namespaces[key] = &namespaceEntry{
+		namespaces: namespaces,
+		expires:    time.Now().Add(aggregation.DiscoveryInterval),
+	}
+	c.mutex.Unlock()
+
+	return namespaces, nil
+}
+
+// List the databases and collections matching the patterns of the aggregation within the query timeout
+func (c *Collector) discoverNamespaces(ctx context.Context, aggregation *Aggregation, srv *server) ([]namespace, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.QueryTimeout+maxTimeGrace)
+	defer cancel()
+
+	opts := &QueryOptions{MaxTime: c.config.QueryTimeout}
+	databases := []string{aggregation.Database}
+
+	if aggregation.matchDatabase != nil {
+		names, err := srv.driver.ListDatabaseNames(ctx, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list databases")
+		}
+
+		databases = databases[:0]
+		for _, name := range names {
+			if aggregation.matchDatabase(name) {
+				databases = append(databases, name)
+			}
+		}
+	}
+
+	var namespaces []namespace
+	for _, database := range databases {
+		if aggregation.matchCollection == nil {
+			namespaces = append(namespaces, namespace{database, aggregation.Collection})
+			continue
+		}
+
+		names, err := srv.driver.ListCollectionNames(ctx, database, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list collections of database %s", database)
+		}
+
+		for _, name := range names {
+			if aggregation.matchCollection(name) {
+				namespaces = append(namespaces, namespace{database, name})
+			}
+		}
+	}
+
+	return namespaces, nil
+}
@@ -0,0 +1,169 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Valid read concern levels
+var readConcernLevels = []string{"local", "available", "majority", "linearizable", "snapshot"}
+
+// QueryOptions are applied to a single operation executed by the driver
+type QueryOptions struct {
+	ReadPreference *readpref.ReadPref
+	ReadConcern    *readconcern.ReadConcern
+	AllowDiskUse   bool
+	Hint           interface{}
+	Collation      *options.Collation
+	BatchSize      int32
+	Comment        string
+	MaxTime        time.Duration
+}
+
+// Parse a read preference mode like secondaryPreferred
+func ParseReadPreference(mode string, opts ...readpref.Option) (*readpref.ReadPref, error) {
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return readpref.New(m, opts...)
+}
+
+// Parse a read concern level like majority
+func ParseReadConcern(level string) (*readconcern.ReadConcern, error) {
+	for _, valid := range readConcernLevels {
+		if level == valid {
+			return &readconcern.ReadConcern{Level: level}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown read concern %s provided. Only [%s] are valid options", level, strings.Join(readConcernLevels, ", "))
+}
+
+// Build the query options from the aggregation configuration
+func (aggregation *Aggregation) buildQueryOptions() (*QueryOptions, error) {
+	opts := &QueryOptions{
+		AllowDiskUse: aggregation.AllowDiskUse,
+		Collation:    aggregation.Collation,
+		BatchSize:    aggregation.BatchSize,
+		Comment:      aggregation.Comment,
+	}
+
+	if aggregation.ReadPreference != "" {
+		rp, err := ParseReadPreference(aggregation.ReadPreference)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid read preference")
+		}
+
+		opts.ReadPreference = rp
+	}
+
+	if aggregation.ReadConcern != "" {
+		rc, err := ParseReadConcern(aggregation.ReadConcern)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid read concern")
+		}
+
+		opts.ReadConcern = rc
+	}
+
+	// A hint is either the name of an index or an index specification document
+	if strings.HasPrefix(strings.TrimSpace(aggregation.Hint), "{") {
+		var hint bson.D
+		err := bson.UnmarshalExtJSON([]byte(aggregation.Hint), false, &hint)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode json hint")
+		}
+
+		opts.Hint = hint
+	} else if aggregation.Hint != "" {
+		opts.Hint = aggregation.Hint
+	}
+
+	return opts, nil
+}
+
+// Collection options from query options
+func (opts *QueryOptions) collectionOptions() *options.CollectionOptions {
+	o := options.Collection()
+	if opts == nil {
+		return o
+	}
+
+	if opts.ReadPreference != nil {
+		o.SetReadPreference(opts.ReadPreference)
+	}
+
+	if opts.ReadConcern != nil {
+		o.SetReadConcern(opts.ReadConcern)
+	}
+
+	return o
+}
+
+// Aggregate options from query options
+func (opts *QueryOptions) aggregateOptions() *options.AggregateOptions {
+	o := options.Aggregate()
+	if opts == nil {
+		return o
+	}
+
+	if opts.AllowDiskUse {
+		o.SetAllowDiskUse(true)
+	}
+
+	if opts.Hint != nil {
+		o.SetHint(opts.Hint)
+	}
+
+	if opts.Collation != nil {
+		o.SetCollation(opts.Collation)
+	}
+
+	if opts.BatchSize > 0 {
+		o.SetBatchSize(opts.BatchSize)
+	}
+
+	if opts.Comment != "" {
+		o.SetComment(opts.Comment)
+	}
+
+	if opts.MaxTime > 0 {
+		o.SetMaxTime(opts.MaxTime)
+	}
+
+	return o
+}
+
+// Add maxTimeMS to a command document if a time limit is set and the command does not specify one already
+func (opts *QueryOptions) command(command bson.D) bson.D {
+	if opts == nil || opts.MaxTime <= 0 {
+		return command
+	}
+
+	for _, elem := range command {
+		if elem.Key == "maxTimeMS" {
+			return command
+		}
+	}
+
+	return append(append(bson.D{}, command...), bson.E{Key: "maxTimeMS", Value: opts.MaxTime.Milliseconds()})
+}
+
+// Command options from query options
+func (opts *QueryOptions) runCmdOptions() *options.RunCmdOptions {
+	o := options.RunCmd()
+	if opts != nil && opts.ReadPreference != nil {
+		o.SetReadPreference(opts.ReadPreference)
+	}
+
+	return o
+}
@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A target connector connects to a target which is not registered as server, like a MongoDB URI.
+// It returns the server name used as label and the connected driver.
+type TargetConnector func(ctx context.Context, target string) (string, Driver, error)
+
+// Pass a connector which is used to probe targets which are not registered as server
+func WithTargetConnector(connector TargetConnector) option {
+	return func(c *Collector) {
+		c.connector = connector
+	}
+}
+
+// Return the servers an aggregation is executed on within the scope
+func (c *Collector) scopeServers(aggregation *Aggregation, s scope) []*server {
+	if s.target == nil {
+		return c.GetServers(aggregation.Servers)
+	}
+
+	// Ad-hoc targets execute all aggregations regardless of the servers they are bound to
+	if s.target.adhoc {
+		return []*server{s.target}
+	}
+
+	for _, srv := range c.GetServers(aggregation.Servers) {
+		if srv == s.target {
+			return []*server{srv}
+		}
+	}
+
+	return nil
+}
+
+// Returns a collector which executes the aggregations matching the filters on a single target (like the blackbox exporter).
+// The target is either the name of a registered server or a target which is connected using the target connector.
+// The returned function must be called once the probe is finished to release the connection to an ad-hoc target.
+func (c *Collector) Probe(ctx context.Context, target string, filters ...Filter) (prometheus.Collector, func(), error) {
+	if servers := c.GetServers([]string{target}); len(servers) == 1 {
+		return &contextCollector{c, ctx, scope{target: servers[0], filters: filters}}, func() {}, nil
+	}
+
+	if c.connector == nil {
+		return nil, nil, fmt.Errorf("unknown target %s", target)
+	}
+
+	name, driver, err := c.connector(ctx, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv := &server{
+		name:   name,
+		driver: driver,
+		up:     true,
+		adhoc:  true,
+		ready:  make(chan struct{}),
+	}
+
+	close(srv.ready)
+
+	release := func() {
+		if err := driver.Disconnect(context.Background()); err != nil {
+			c.logger.Errorf("failed to disconnect from target %s: %s", name, err)
+		}
+	}
+
+	return &contextCollector{c, ctx, scope{target: srv, filters: filters}}, release, nil
+}
@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Take over the connections, cached metrics and discovered namespaces of a previous collector (like after a config reload).
+// Servers keep their connection if their fingerprint did not change, unchanged aggregations keep their cached metrics
+// and discovered namespaces on those servers.
+// Must be called before the background tasks are started.
+func (c *Collector) Adopt(previous *Collector) {
+	adopted := make(map[string]bool)
+
+	for _, srv := range c.servers {
+		for _, old := range previous.servers {
+			if srv.fingerprint == "" || old.name != srv.name || old.fingerprint != srv.fingerprint {
+				continue
+			}
+
+			// A server which is not connected yet is connected again by the new collector
+			select {
+			case <-old.ready:
+			default:
+				continue
+			}
+
+			old.mutex.Lock()
+			old.adopted = true
+			srv.up, srv.lastPing, srv.err = old.up, old.lastPing, old.err
+			old.mutex.Unlock()
+
+			c.logger.Debugf("reuse connection to server %s", srv.name)
+			srv.driver = old.driver
+			srv.connect = nil
+			close(srv.ready)
+			adopted[srv.name] = true
+		}
+	}
+
+	aggregations := make(map[*Aggregation]*Aggregation)
+	for _, aggregation := range c.aggregations {
+		for _, old := range previous.aggregations {
+			if aggregation.fingerprint() == old.fingerprint() {
+				aggregations[old] = aggregation
+			}
+		}
+	}
+
+	previous.mutex.Lock()
+	defer previous.mutex.Unlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, e := range previous.cache {
+		// Without a watcher push events would be missed until the new watcher has been started
+		if aggregation, ok := aggregations[key.aggregation]; ok && adopted[key.server] && aggregation.Mode != ModePush {
+			c.cache[cacheKey{aggregation, key.server, key.ns}] = e
+		}
+	}
+
+	for key, e := range previous.namespaces {
+		if aggregation, ok := aggregations[key.aggregation]; ok && adopted[key.server] {
+			c.namespaces[namespaceKey{aggregation, key.server}] = e
+		}
+	}
+}
+
+// The fingerprint of an aggregation configuration
+func (aggregation *Aggregation) fingerprint() string {
+	b, _ := json.Marshal(aggregation)
+	return string(b)
+}
+
+// Stop all background tasks and disconnect from all servers which have not been adopted by another collector.
+// Change streams are closed and running background tasks are awaited until the context is done.
+func (c *Collector) Shutdown(ctx context.Context) error {
+	c.cancel()
+
+	var result error
+	done := make(chan struct{})
+	go func() {
+		c.tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		result = multierror.Append(result, fmt.Errorf("background tasks did not stop in time: %w", ctx.Err()))
+	}
+
+	for _, srv := range c.servers {
+		srv.mutex.Lock()
+		adopted := srv.adopted
+		srv.mutex.Unlock()
+
+		if adopted {
+			continue
+		}
+
+		if err := srv.driver.Disconnect(ctx); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The outcome of an aggregation executed once on a server and namespace
+type RunResult struct {
+	Aggregation string
+	Server      string
+	Database    string
+	Collection  string
+	Rows        int
+	Metrics     []prometheus.Metric
+	Duration    time.Duration
+	Err         error
+}
+
+// Execute the aggregations matching the filters once on each of their servers, cached metrics are ignored.
+// Aggregations are executed one after another, servers which are not connected before the context is done
+// are reported with ErrNotConnected.
+func (c *Collector) Run(ctx context.Context, filters ...Filter) []RunResult {
+	s := scope{filters: filters}
+	var results []RunResult
+
+	for i, aggregation := range c.aggregations {
+		if !s.matchAggregation(aggregation, i) {
+			continue
+		}
+
+		for _, srv := range c.GetServers(aggregation.Servers) {
+			result := RunResult{
+				Aggregation: aggregation.label(i),
+				Server:      srv.name,
+			}
+
+			select {
+			case <-srv.ready:
+			case <-ctx.Done():
+				result.Err = ErrNotConnected
+				srv.mutex.Lock()
+				if srv.err != nil {
+					result.Err = fmt.Errorf("%w: %s", ErrNotConnected, srv.err)
+				}
+				srv.mutex.Unlock()
+
+				results = append(results, result)
+				continue
+			}
+
+			start := time.Now()
+			namespaces, err := c.resolveNamespaces(ctx, aggregation, srv)
+			if err != nil {
+				result.Duration = time.Since(start)
+				result.Err = err
+				results = append(results, result)
+				continue
+			}
+
+			for _, ns := range namespaces {
+				result := result
+				result.Database, result.Collection = ns.database, ns.collection
+
+				start := time.Now()
+				result.Metrics, result.Rows, result.Err = c.execute(ctx, aggregation, srv, ns)
+				result.Duration = time.Since(start)
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	//Interval in which connected servers are pinged
+	DefaultPingInterval = 15 * time.Second
+	//Initial wait time before a failed connection attempt is retried
+	minConnectBackoff = time.Second
+	//The wait time between connection attempts is doubled up to this limit
+	maxConnectBackoff = time.Minute
+)
+
+// A server needs a driver (implementation) and a unique name
+type server struct {
+	name        string
+	driver      Driver
+	connect     func(ctx context.Context) error
+	watch       func(ctx context.Context, driver Driver)
+	fingerprint string
+	adopted     bool
+	// Ad-hoc targets are connected per probe, their results are neither cached nor counted
+	adhoc    bool
+	up       bool
+	lastPing time.Time
+	err      error
+	ready    chan struct{}
+	mutex    sync.Mutex
+}
+
+type ServerOption func(srv *server)
+
+// Connect the server lazily using the given function.
+// Until the connection has been established the server is considered down and its aggregations are skipped.
+func WithConnect(connect func(ctx context.Context) error) ServerOption {
+	return func(srv *server) {
+		srv.connect = connect
+	}
+}
+
+// Run a background task for the server once it is connected, like watching for changed credentials.
+// The task gets cancelled once the collector shuts down.
+func WithWatch(watch func(ctx context.Context, driver Driver)) ServerOption {
+	return func(srv *server) {
+		srv.watch = watch
+	}
+}
+
+// A fingerprint of the server configuration.
+// A collector adopts the connection of a previous collector if the fingerprints of a server match.
+func WithFingerprint(fingerprint string) ServerOption {
+	return func(srv *server) {
+		srv.fingerprint = fingerprint
+	}
+}
+
+// Reports whether the server is connected and the last ping succeeded
+func (srv *server) isUp() bool {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return srv.up
+}
+
+// Update the server state from the result of the last connection attempt or ping
+func (srv *server) setUp(err error) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.up = err == nil
+	srv.err = err
+
+	if err == nil {
+		srv.lastPing = time.Now()
+	}
+}
+
+// Start connecting the registered servers in the background.
+// Servers which fail to connect are retried with an exponential backoff, connected servers are pinged periodically.
+// This is a non blocking operation.
+func (c *Collector) StartServerMonitor() error {
+	for _, srv := range c.servers {
+		c.tasks.Add(1)
+		go func(srv *server) {
+			defer c.tasks.Done()
+			c.monitor(srv)
+		}(srv)
+
+		if srv.watch != nil {
+			c.tasks.Add(1)
+			go func(srv *server) {
+				defer c.tasks.Done()
+				srv.watch(c.ctx, srv.driver)
+			}(srv)
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) monitor(srv *server) {
+	connected := srv.connect == nil
+	backoff := minConnectBackoff
+	interval := c.config.PingInterval
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+
+	for {
+		wait := interval
+
+		if !connected {
+			if err := c.connect(srv); err != nil {
+				c.logger.Errorf("failed to connect to server %s, retry in %s: %s", srv.name, backoff, err)
+				c.updateServerUp(srv, err)
+
+				wait = backoff
+				backoff *= 2
+				if backoff > maxConnectBackoff {
+					backoff = maxConnectBackoff
+				}
+			} else {
+				connected = true
+				close(srv.ready)
+			}
+		}
+
+		if connected {
+			c.updateServerUp(srv, c.ping(srv))
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Collector) connect(srv *server) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.QueryTimeout)
+	defer cancel()
+
+	c.logger.Infof("connect to server %s", srv.name)
+	return srv.connect(ctx)
+}
+
+func (c *Collector) ping(srv *server) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.QueryTimeout)
+	defer cancel()
+
+	err := srv.driver.Ping(ctx, nil)
+	if err != nil {
+		c.logger.Errorf("failed to ping server %s: %s", srv.name, err)
+	}
+
+	return err
+}
+
+// Update the server state and the up gauge
+func (c *Collector) updateServerUp(srv *server, err error) {
+	srv.setUp(err)
+
+	if c.serverUp == nil {
+		return
+	}
+
+	value := 0.0
+	if err == nil {
+		value = 1
+	}
+
+	c.serverUp.With(prometheus.Labels{"server": srv.name}).Set(value)
+}
@@ -0,0 +1,149 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	//Ready if all servers are up and all push watchers are running
+	ReadinessAll = "all"
+	//Ready if at least one server is up
+	ReadinessAny = "any"
+)
+
+// Status of the collector servers and push watchers
+type Status struct {
+	Servers  []ServerStatus  `json:"servers"`
+	Watchers []WatcherStatus `json:"watchers"`
+}
+
+// Status of a server
+type ServerStatus struct {
+	Name string `json:"name"`
+	Up   bool   `json:"up"`
+	// Time of the last successful connection attempt or ping, nil if the server has not been pinged yet
+	LastPing *time.Time `json:"lastPing,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Status of a changestream watcher of an aggregation in push mode
+type WatcherStatus struct {
+	Aggregation string `json:"aggregation"`
+	Server      string `json:"server"`
+	Running     bool   `json:"running"`
+	// The watcher failed and is restarted with a backoff, meanwhile the aggregation is pulled
+	Fallback bool   `json:"fallback,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// A changestream watcher started for an aggregation in push mode
+type watcher struct {
+	source      *Aggregation
+	aggregation string
+	server      string
+	running     bool
+	fallback    bool
+	err         error
+	mutex       sync.Mutex
+}
+
+// Update the watcher state, a watcher which stopped is in fallback until it is running again
+func (w *watcher) setRunning(running bool, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.running = running
+	w.fallback = !running
+	w.err = err
+}
+
+func (w *watcher) isRunning() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.running
+}
+
+// Return the current status of all servers and push watchers
+func (c *Collector) Status() Status {
+	status := Status{
+		Servers:  []ServerStatus{},
+		Watchers: []WatcherStatus{},
+	}
+
+	for _, srv := range c.servers {
+		srv.mutex.Lock()
+		s := ServerStatus{
+			Name: srv.name,
+			Up:   srv.up,
+		}
+
+		if !srv.lastPing.IsZero() {
+			lastPing := srv.lastPing
+			s.LastPing = &lastPing
+		}
+
+		if srv.err != nil {
+			s.Error = srv.err.Error()
+		}
+		srv.mutex.Unlock()
+
+		status.Servers = append(status.Servers, s)
+	}
+
+	c.mutex.Lock()
+	watchers := c.watchers
+	c.mutex.Unlock()
+
+	for _, w := range watchers {
+		w.mutex.Lock()
+		s := WatcherStatus{
+			Aggregation: w.aggregation,
+			Server:      w.server,
+			Running:     w.running,
+			Fallback:    w.fallback,
+		}
+
+		if w.err != nil {
+			s.Error = w.err.Error()
+		}
+		w.mutex.Unlock()
+
+		status.Watchers = append(status.Watchers, s)
+	}
+
+	return status
+}
+
+// Reports whether the collector is ready according to the readiness mode.
+// In mode all every server must be up and every push watcher must be running or have fallen back to pull,
+// in mode any at least one server must be up.
+func (s Status) Ready(mode string) (bool, error) {
+	switch mode {
+	case ReadinessAll:
+		for _, srv := range s.Servers {
+			if !srv.Up {
+				return false, nil
+			}
+		}
+
+		for _, w := range s.Watchers {
+			if !w.Running && !w.Fallback {
+				return false, nil
+			}
+		}
+
+		return true, nil
+
+	case ReadinessAny:
+		for _, srv := range s.Servers {
+			if srv.Up {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown readiness mode %s provided. Only [%s, %s] are valid options", mode, ReadinessAll, ReadinessAny)
+}
@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Decode and validate the settings of an aggregation with the defaults applied.
+// All invalid settings are reported joined, not only the first one.
+func (c *Collector) parseAggregation(aggregation *Aggregation) error {
+	var errs []error
+	for _, name := range aggregation.Servers {
+		if len(c.GetServers([]string{name})) == 0 {
+			errs = append(errs, fmt.Errorf("aggregation bound to server %s which has not been found", name))
+		}
+	}
+
+	for _, registered := range c.aggregations {
+		if aggregation.Name != "" && registered.Name == aggregation.Name {
+			errs = append(errs, fmt.Errorf("aggregation %s is already registered", aggregation.Name))
+		}
+	}
+
+	switch aggregation.Mode {
+	case ModePull, ModePush:
+	default:
+		errs = append(errs, fmt.Errorf("unknown aggregation mode %s provided. Only [%s, %s] are valid options", aggregation.Mode, ModePull, ModePush))
+	}
+
+	switch aggregation.Kind {
+	case KindAggregate:
+		if err := bson.UnmarshalExtJSON([]byte(aggregation.Pipeline), false, &aggregation.pipeline); err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode json aggregation pipeline: %w", err))
+		}
+	case KindCommand:
+		if err := bson.UnmarshalExtJSON([]byte(aggregation.Command), false, &aggregation.command); err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode json command: %w", err))
+		} else if len(aggregation.command) == 0 {
+			errs = append(errs, errors.New("command must not be empty"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown aggregation kind %s provided. Only [%s, %s] are valid options", aggregation.Kind, KindAggregate, KindCommand))
+	}
+
+	errs = append(errs, c.compilePatterns(aggregation), c.validateMetrics(aggregation))
+
+	opts, err := aggregation.buildQueryOptions()
+	errs = append(errs, err)
+	aggregation.queryOptions = opts
+
+	return errors.Join(errs...)
+}
+
+// Validate the metric name, type and label names
+func (metric *Metric) Validate() error {
+	if !model.IsValidMetricName(model.LabelValue(metric.Name)) {
+		return fmt.Errorf("invalid metric name %q", metric.Name)
+	}
+
+	if metric.Type != "" && metric.Type != TypeGauge {
+		return ErrInvalidType
+	}
+
+	labels := make(map[string]bool)
+	for _, label := range metric.labelNames() {
+		if !model.LabelName(label).IsValid() || strings.HasPrefix(label, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid label name %q", label)
+		}
+
+		if labels[label] {
+			return fmt.Errorf("duplicate label name %q", label)
+		}
+
+		labels[label] = true
+	}
+
+	return nil
+}
+
+// All label names of the metric including the const labels
+func (metric *Metric) labelNames() []string {
+	var constLabels []string
+	for label := range metric.ConstLabels {
+		constLabels = append(constLabels, label)
+	}
+
+	sort.Strings(constLabels)
+	return append(append([]string{}, metric.Labels...), constLabels...)
+}
+
+// Validate the metrics of an aggregation against the labels added by the aggregation and the metrics
+// of already registered aggregations. Metrics with the same name must have the same help and label names.
+// The errors of all invalid metrics are returned joined.
+func (c *Collector) validateMetrics(aggregation *Aggregation) error {
+	defined := make(map[string]string)
+	for _, registered := range c.aggregations {
+		for _, metric := range registered.Metrics {
+			defined[metric.Name] = registered.dimensions(metric)
+		}
+	}
+
+	var errs []error
+	for _, metric := range aggregation.Metrics {
+		if err := metric.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid metric %s: %w", metric.Name, err))
+			continue
+		}
+
+		for _, label := range aggregation.labelNames() {
+			for _, name := range metric.labelNames() {
+				if label == name {
+					errs = append(errs, fmt.Errorf("invalid metric %s: label %s is added by the exporter", metric.Name, label))
+				}
+			}
+		}
+
+		dimensions, ok := defined[metric.Name]
+		if ok && dimensions != aggregation.dimensions(metric) {
+			errs = append(errs, fmt.Errorf("metric %s is already defined with a different help or different labels", metric.Name))
+			continue
+		}
+
+		defined[metric.Name] = aggregation.dimensions(metric)
+	}
+
+	return errors.Join(errs...)
+}
+
+// The help and all label names of a metric exported by the aggregation
+func (aggregation *Aggregation) dimensions(metric *Metric) string {
+	labels := append(aggregation.labelNames(), metric.labelNames()...)
+	sort.Strings(labels)
+
+	return metric.Help + "\x00" + strings.Join(labels, ",")
+}
@@ -9,23 +9,61 @@ import (
 
 // Config defaults
 const (
-	DefaultServerName   = "main"
-	DefaultMongoDBURI   = "mongodb://localhost:27017"
-	DefaultMetricsPath  = "/metrics"
-	DefaultBindAddr     = ":9412"
-	DefaultQueryTimeout = 10 * time.Second
-	HealthzPath         = "/healthz"
-	DefaultLogEncoder   = "json"
-	DefaultLogLevel     = "warn"
+	DefaultServerName          = "main"
+	DefaultMongoDBURI          = "mongodb://localhost:27017"
+	DefaultMetricsPath         = "/metrics"
+	DefaultBindAddr            = ":9412"
+	DefaultQueryTimeout        = 10 * time.Second
+	HealthzPath                = "/healthz"
+	ReadyzPath                 = "/readyz"
+	ProbePath                  = "/probe"
+	ReloadPath                 = "/-/reload"
+	ConfigPath                 = "/config"
+	DefaultLogEncoder          = "json"
+	DefaultLogLevel            = "warn"
+	DefaultScrapeTimeoutOffset = 500 * time.Millisecond
+	SecretFilesReloadInterval  = 30 * time.Second
+	DefaultShutdownTimeout     = 30 * time.Second
 )
 
+// The cache of a v1.0 or v2.0 config in seconds, these formats can not distinguish an unset cache from a disabled one.
+// 0 applies the default cache.
+func LegacyCache(seconds int64) *time.Duration {
+	if seconds == 0 {
+		return nil
+	}
+
+	cache := time.Duration(seconds) * time.Second
+	return &cache
+}
+
+// Reports whether a path is used by an endpoint of the exporter and can not be used as metrics path
+func IsReservedPath(path string) bool {
+	switch path {
+	case HealthzPath, ReadyzPath, ProbePath, ReloadPath, ConfigPath:
+		return true
+	}
+
+	return false
+}
+
 // A configuration format to build a Collector from
 type Config interface {
 	GetBindAddr() string
 	GetMetricsPath() string
+	GetMetricsPaths() []MetricsPath
+	GetWeb() *Web
+	Validate(opts ValidateOptions) error
 	Build() (*collector.Collector, error)
 }
 
+// An additional metrics path which only serves a subset of the aggregations
+type MetricsPath struct {
+	Path         string
+	Aggregations []string
+	Groups       []string
+}
+
 var Counter = prometheus.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "mongodb_query_exporter_query_total",
@@ -33,3 +71,52 @@ var Counter = prometheus.NewCounterVec(
 	},
 	[]string{"aggregation", "server", "result"},
 )
+
+var ServerUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mongodb_query_exporter_server_up",
+		Help: "Whether the MongoDB server is connected and the last ping succeeded",
+	},
+	[]string{"server"},
+)
+
+var ConfigLastReloadSuccessful = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "mongodb_query_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	},
+)
+
+var ConfigLastReloadSuccessTimestamp = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "mongodb_query_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	},
+)
+
+// Labels of the explain gauges
+var explainLabels = []string{"aggregation", "server", "database", "collection"}
+
+var Explain = collector.ExplainMetrics{
+	DocsExamined: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_query_exporter_explain_docs_examined",
+			Help: "Documents examined by the winning plan of an aggregation during the last explain check",
+		},
+		explainLabels,
+	),
+	KeysExamined: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_query_exporter_explain_keys_examined",
+			Help: "Index keys examined by the winning plan of an aggregation during the last explain check",
+		},
+		explainLabels,
+	),
+	CollectionScan: prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_query_exporter_explain_collection_scan",
+			Help: "Whether the winning plan of an aggregation contained a COLLSCAN stage during the last explain check",
+		},
+		explainLabels,
+	),
+}
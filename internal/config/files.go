@@ -0,0 +1,242 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Lists which are concatenated if they are defined in multiple config files
+var mergedLists = []string{"servers", "templates", "aggregations", "metrics", "metricsPaths"}
+
+// Config files merged into a single config
+type Files struct {
+	Names    []string
+	Settings map[string]interface{}
+	// The file and index of each merged list item, like aggregations[3] => b.yaml, aggregations[0]
+	origins map[string]origin
+}
+
+type origin struct {
+	file  string
+	index int
+}
+
+// Expand the paths into config files.
+// Directories are expanded to the yaml files they contain and globs to the matching files, both in lexical order.
+func Expand(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			var err error
+			matches, err = filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid config glob %s: %w", path, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no config files match %s", path)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				files = append(files, match)
+				continue
+			}
+
+			entries, err := os.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+					files = append(files, filepath.Join(match, entry.Name()))
+				}
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config files found in %s", strings.Join(paths, ", "))
+	}
+
+	return files, nil
+}
+
+// Read and merge the config files.
+// Servers, templates, aggregations, metrics and metrics paths are concatenated, all other settings are merged.
+// A setting defined with different values, servers, templates and aggregations with the same name and metrics with the
+// same name defined in different files are reported as conflicts (with the file name).
+func Merge(names []string) (*Files, error) {
+	files := &Files{
+		Names:    names,
+		Settings: make(map[string]interface{}),
+		origins:  make(map[string]origin),
+	}
+
+	var errs ValidationErrors
+	settings := make(map[string]string)
+	named := make(map[string]string)
+
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		for _, key := range sortedKeys(doc) {
+			value := doc[key]
+			list := mergedList(key)
+			if list == "" {
+				merge(&errs, name, key, files.Settings, key, value, settings)
+				continue
+			}
+
+			items, ok := value.([]interface{})
+			if !ok {
+				errs = append(errs, &ValidationError{File: name, Path: key, Err: fmt.Errorf("must be a list")})
+				continue
+			}
+
+			merged, _ := files.Settings[list].([]interface{})
+			for i, item := range items {
+				checkNames(&errs, name, list, fmt.Sprintf("%s[%d]", key, i), item, named)
+				files.origins[fmt.Sprintf("%s[%d]", list, len(merged))] = origin{name, i}
+				merged = append(merged, item)
+			}
+
+			files.Settings[list] = merged
+		}
+	}
+
+	return files, errs.Err()
+}
+
+// Merge a setting into the parent settings, conflicting values are reported
+func merge(errs *ValidationErrors, name, path string, parent map[string]interface{}, key string, value interface{}, settings map[string]string) {
+	existing, exists := parent[key]
+	existingMap, isMap := existing.(map[string]interface{})
+	valueMap, valueIsMap := value.(map[string]interface{})
+
+	switch {
+	case !exists:
+		parent[key] = value
+		settings[path] = name
+	case isMap && valueIsMap:
+		for _, k := range sortedKeys(valueMap) {
+			merge(errs, name, path+"."+k, existingMap, k, valueMap[k], settings)
+		}
+	case !reflect.DeepEqual(existing, value):
+		*errs = append(*errs, &ValidationError{File: name, Path: path, Err: fmt.Errorf("already set to a different value in %s", setBy(settings, path))})
+	}
+}
+
+// The file which set a setting or one of its parents
+func setBy(settings map[string]string, path string) string {
+	for {
+		if name, ok := settings[path]; ok {
+			return name
+		}
+
+		i := strings.LastIndex(path, ".")
+		if i == -1 {
+			return ""
+		}
+
+		path = path[:i]
+	}
+}
+
+// Report servers, templates, aggregations and metrics which have already been defined in a different file
+func checkNames(errs *ValidationErrors, name, key, path string, item interface{}, named map[string]string) {
+	check := func(kind, path string, item interface{}) {
+		m, _ := item.(map[string]interface{})
+		n, ok := m["name"].(string)
+		if !ok || n == "" {
+			return
+		}
+
+		if file, ok := named[kind+"/"+n]; ok && file != name {
+			*errs = append(*errs, &ValidationError{File: name, Path: path, Err: fmt.Errorf("%s %s is already defined in %s", kind, n, file)})
+			return
+		}
+
+		named[kind+"/"+n] = name
+	}
+
+	switch key {
+	case "servers":
+		check("server", path, item)
+	case "templates":
+		check("template", path, item)
+	case "aggregations":
+		check("aggregation", path, item)
+
+		m, _ := item.(map[string]interface{})
+		metrics, _ := m["metrics"].([]interface{})
+		for i, metric := range metrics {
+			check("metric", fmt.Sprintf("%s.metrics[%d]", path, i), metric)
+		}
+	case "metrics":
+		check("metric", path, item)
+	}
+}
+
+var listItem = regexp.MustCompile(`^(\w+)\[(\d+)\]`)
+
+// Return the file and the location within the file of a location in the merged config (like aggregations[3].metrics[0]).
+// The file is empty if the location is not within a merged list.
+func (f *Files) Locate(path string) (string, string) {
+	m := listItem.FindStringSubmatch(path)
+	if m == nil {
+		return "", path
+	}
+
+	o, ok := f.origins[m[0]]
+	if !ok {
+		return "", path
+	}
+
+	return o.file, m[1] + "[" + strconv.Itoa(o.index) + "]" + path[len(m[0]):]
+}
+
+// The name of the merged list (keys are case insensitive) or an empty string
+func mergedList(key string) string {
+	for _, list := range mergedLists {
+		if strings.EqualFold(list, key) {
+			return list
+		}
+	}
+
+	return ""
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	return dir
+}
+
+func TestExpand(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yaml":     "",
+		"conf.d/b.yaml":   "",
+		"conf.d/a.yml":    "",
+		"conf.d/c.txt":    "",
+		"conf.d/d/e.yaml": "",
+	})
+
+	t.Run("Directories are expanded to the yaml files they contain", func(t *testing.T) {
+		files, err := Expand([]string{filepath.Join(dir, "config.yaml"), filepath.Join(dir, "conf.d")})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "config.yaml"),
+			filepath.Join(dir, "conf.d", "a.yml"),
+			filepath.Join(dir, "conf.d", "b.yaml"),
+		}, files)
+	})
+
+	t.Run("Globs are expanded to the matching files", func(t *testing.T) {
+		files, err := Expand([]string{filepath.Join(dir, "conf.d", "*.yaml")})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "conf.d", "b.yaml")}, files)
+	})
+
+	t.Run("Globs without matches fail", func(t *testing.T) {
+		_, err := Expand([]string{filepath.Join(dir, "*.json")})
+		assert.Error(t, err)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("Lists are concatenated and settings are merged", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"a.yaml": `version: 3.0
+global:
+  queryTimeout: 5s
+servers:
+- name: main
+aggregations:
+- name: users
+  metrics:
+  - name: users_total
+`,
+			"b.yaml": `version: 3.0
+global:
+  defaultCache: 1m
+aggregations:
+- name: orders
+  metrics:
+  - name: orders_total
+- name: invoices
+`,
+		})
+
+		files, err := Merge([]string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"queryTimeout": "5s", "defaultCache": "1m"}, files.Settings["global"])
+		assert.Len(t, files.Settings["servers"], 1)
+		assert.Len(t, files.Settings["aggregations"], 3)
+
+		file, path := files.Locate("aggregations[2].metrics[0]")
+		assert.Equal(t, filepath.Join(dir, "b.yaml"), file)
+		assert.Equal(t, "aggregations[1].metrics[0]", path)
+
+		file, path = files.Locate("global")
+		assert.Equal(t, "", file)
+		assert.Equal(t, "global", path)
+	})
+
+	t.Run("Conflicts are reported with the file names", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"a.yaml": `version: 3.0
+log:
+  level: info
+servers:
+- name: main
+aggregations:
+- name: users
+  metrics:
+  - name: users_total
+  - name: users_total
+`,
+			"b.yaml": `version: 2.0
+log:
+  level: debug
+servers:
+- name: main
+aggregations:
+- name: users
+  metrics:
+  - name: users_total
+`,
+		})
+
+		a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+		_, err := Merge([]string{a, b})
+
+		errs, ok := err.(ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 5)
+		assert.Equal(t, b+": aggregations[0]: aggregation users is already defined in "+a, errs[0].Error())
+		assert.Equal(t, b+": aggregations[0].metrics[0]: metric users_total is already defined in "+a, errs[1].Error())
+		assert.Equal(t, b+": log.level: already set to a different value in "+a, errs[2].Error())
+		assert.Equal(t, b+": servers[0]: server main is already defined in "+a, errs[3].Error())
+		assert.Equal(t, b+": version: already set to a different value in "+a, errs[4].Error())
+	})
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Placeholder for redacted credentials
+const Redacted = "<redacted>"
+
+// Return the config as generic settings with env variables in URIs expanded and credentials redacted.
+// Passwords and secret options in URIs (like uri or mongodb.uri) and any password setting are replaced.
+func Redact(conf interface{}) (map[string]interface{}, error) {
+	b, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]interface{})
+	if err := yaml.Unmarshal(b, &settings); err != nil {
+		return nil, err
+	}
+
+	redact(settings)
+	return settings, nil
+}
+
+func redact(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, v := range value {
+			s, isString := v.(string)
+			switch {
+			case isString && strings.EqualFold(key, "uri"):
+				value[key] = RedactURI(os.ExpandEnv(s))
+			case isString && strings.EqualFold(key, "password") && s != "":
+				value[key] = Redacted
+			default:
+				redact(v)
+			}
+		}
+	case []interface{}:
+		for _, v := range value {
+			redact(v)
+		}
+	}
+}
+
+// Replace the password and secret options (like tlsCertificateKeyFilePassword or an AWS_SESSION_TOKEN auth property)
+// of a MongoDB URI, the username and other options are kept.
+// The URI is not parsed as URL as MongoDB URIs may contain multiple hosts.
+func RedactURI(uri string) string {
+	scheme := strings.Index(uri, "://")
+	if scheme == -1 {
+		return uri
+	}
+
+	authority, path := uri[scheme+3:], ""
+	if i := strings.IndexAny(authority, "/?"); i != -1 {
+		authority, path = authority[:i], authority[i:]
+	}
+
+	return uri[:scheme+3] + redactUserinfo(authority) + redactOptions(path)
+}
+
+func redactUserinfo(authority string) string {
+	at := strings.LastIndex(authority, "@")
+	if at == -1 {
+		return authority
+	}
+
+	username, _, hasPassword := strings.Cut(authority[:at], ":")
+	if !hasPassword {
+		return authority
+	}
+
+	return username + ":" + Redacted + authority[at:]
+}
+
+// Redact the values of secret options, options may be separated by & or ;
+func redactOptions(path string) string {
+	path, query, hasQuery := strings.Cut(path, "?")
+	if !hasQuery {
+		return path
+	}
+
+	var b strings.Builder
+	b.WriteString(path + "?")
+
+	start := 0
+	for i := 0; i <= len(query); i++ {
+		if i < len(query) && query[i] != '&' && query[i] != ';' {
+			continue
+		}
+
+		b.WriteString(redactOption(query[start:i]))
+		if i < len(query) {
+			b.WriteByte(query[i])
+		}
+
+		start = i + 1
+	}
+
+	return b.String()
+}
+
+func redactOption(option string) string {
+	key, value, hasValue := strings.Cut(option, "=")
+	if !hasValue {
+		return option
+	}
+
+	switch strings.ToLower(key) {
+	case "tlscertificatekeyfilepassword", "sslclientcertificatekeypassword":
+		return key + "=" + Redacted
+	case "authmechanismproperties":
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+
+		properties := strings.Split(value, ",")
+		for i, property := range properties {
+			if name, _, _ := strings.Cut(property, ":"); strings.EqualFold(name, "AWS_SESSION_TOKEN") {
+				properties[i] = name + ":" + Redacted
+			}
+		}
+
+		return key + "=" + strings.Join(properties, ",")
+	}
+
+	return option
+}
@@ -2,6 +2,8 @@ package v1
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"time"
 
@@ -31,6 +33,17 @@ type MongoDB struct {
 	DefaultCollection string
 }
 
+// The fingerprint of the connection settings, a reloaded config reuses the connection if it did not change
+func (mongodb MongoDB) fingerprint() string {
+	b, _ := json.Marshal(struct {
+		URI               string
+		MaxConnections    int32
+		ConnectionTimeout time.Duration
+	}{mongodb.URI, mongodb.MaxConnections, mongodb.ConnectionTimeout})
+
+	return string(b)
+}
+
 // Metric defines an exported metric from a MongoDB aggregation pipeline
 type Metric struct {
 	Cache         int64
@@ -48,11 +61,54 @@ type Metric struct {
 	Labels        []string
 }
 
+// Convert the metric into a collector aggregation with a single metric
+func (metric *Metric) aggregation() *collector.Aggregation {
+	return &collector.Aggregation{
+		Cache:      config.LegacyCache(metric.Cache),
+		Mode:       metric.Mode,
+		Database:   metric.Database,
+		Collection: metric.Collection,
+		Pipeline:   metric.Pipeline,
+		Metrics: []*collector.Metric{
+			{
+				Name:          metric.Name,
+				Type:          metric.Type,
+				Help:          metric.Help,
+				Value:         metric.Value,
+				OverrideEmpty: metric.OverrideEmpty,
+				EmptyValue:    metric.EmptyValue,
+				ConstLabels:   metric.ConstLabels,
+				Labels:        metric.Labels,
+			},
+		},
+	}
+}
+
+// The collector settings derived from the mongodb config
+func (conf *Config) collectorConfig() *collector.Config {
+	return &collector.Config{
+		QueryTimeout:      conf.MongoDB.ConnectionTimeout * time.Second,
+		DefaultCache:      time.Duration(conf.MongoDB.DefaultInterval) * time.Second,
+		DefaultDatabase:   conf.MongoDB.DefaultDatabase,
+		DefaultCollection: conf.MongoDB.DefaultCollection,
+	}
+}
+
 // Get address where the http server should be bound to
 func (conf *Config) GetBindAddr() string {
 	return conf.Bind
 }
 
+// Web config is not supported, use --web.config.file instead
+func (conf *Config) GetWeb() *config.Web {
+	return nil
+}
+
+// Additional metrics paths are not supported
+func (conf *Config) GetMetricsPaths() []config.MetricsPath {
+	return nil
+}
+
 // Get metrics path
 func (conf *Config) GetMetricsPath() string {
 	return "/metrics"
@@ -85,30 +141,33 @@ func (conf *Config) Build() (*collector.Collector, error) {
 		conf.MongoDB.URI = "mongodb://localhost:27017"
 	}
 
+	if conf.MongoDB.ConnectionTimeout == 0 {
+		conf.MongoDB.ConnectionTimeout = config.DefaultQueryTimeout / time.Second
+	}
+
 	opts := options.Client().ApplyURI(conf.MongoDB.URI)
 	l.Sugar().Infof("use mongodb hosts %#v", opts.Hosts)
-	l.Sugar().Debug("use mongodb connection context timout of %d", conf.MongoDB.ConnectionTimeout*time.Second)
+	l.Sugar().Debugf("use mongodb connection timeout of %s", conf.MongoDB.ConnectionTimeout*time.Second)
 
-	ctx, cancel := context.WithTimeout(context.Background(), conf.MongoDB.ConnectionTimeout*time.Second)
-	defer cancel()
-	d := &collector.MongoDBDriver{}
-	err = d.Connect(ctx, opts)
-	if err != nil {
-		panic(err)
+	opts.SetConnectTimeout(conf.MongoDB.ConnectionTimeout * time.Second)
+	if conf.MongoDB.MaxConnections > 0 && opts.MaxPoolSize == nil {
+		opts.SetMaxPoolSize(uint64(conf.MongoDB.MaxConnections))
 	}
 
+	d := &collector.MongoDBDriver{}
 	c := collector.New(
-		collector.WithConfig(&collector.Config{
-			QueryTimeout:      conf.MongoDB.ConnectionTimeout,
-			DefaultCache:      time.Duration(conf.MongoDB.DefaultInterval) * time.Second,
-			DefaultDatabase:   conf.MongoDB.DefaultDatabase,
-			DefaultCollection: conf.MongoDB.DefaultCollection,
-		}),
+		collector.WithConfig(conf.collectorConfig()),
 		collector.WithLogger(l.Sugar()),
 		collector.WithCounter(config.Counter),
+		collector.WithServerUp(config.ServerUp),
 	)
 
-	err = c.RegisterServer("main", d)
+	err = c.RegisterServer("main", d,
+		collector.WithFingerprint(conf.MongoDB.fingerprint()),
+		collector.WithConnect(func(ctx context.Context) error {
+			return d.Connect(ctx, opts)
+		}),
+	)
 	if err != nil {
 		return c, err
 	}
@@ -118,26 +177,7 @@ func (conf *Config) Build() (*collector.Collector, error) {
 	}
 
 	for _, metric := range conf.Metrics {
-		err := c.RegisterAggregation(&collector.Aggregation{
-			Cache:      time.Duration(metric.Cache) * time.Second,
-			Mode:       metric.Mode,
-			Database:   metric.Database,
-			Collection: metric.Collection,
-			Pipeline:   metric.Pipeline,
-			Metrics: []*collector.Metric{
-				{
-					Name:          metric.Name,
-					Type:          metric.Type,
-					Help:          metric.Help,
-					Value:         metric.Value,
-					OverrideEmpty: metric.OverrideEmpty,
-					EmptyValue:    metric.EmptyValue,
-					ConstLabels:   metric.ConstLabels,
-					Labels:        metric.Labels,
-				},
-			},
-		})
-
+		err := c.RegisterAggregation(metric.aggregation())
 		if err != nil {
 			return c, err
 		}
@@ -145,3 +185,33 @@ func (conf *Config) Build() (*collector.Collector, error) {
 
 	return c, nil
 }
+
+// Run all checks of Build without connecting to the server.
+// All errors are returned with the location of the invalid setting, the config does not reference any files.
+func (conf *Config) Validate(opts config.ValidateOptions) error {
+	var errs config.ValidationErrors
+
+	if _, err := zap.New(zap.Config{Encoding: "console", Level: conf.LogLevel}); err != nil {
+		errs.Add("logLevel", err)
+	}
+
+	uri := conf.MongoDB.URI
+	if env := os.Getenv("MDBEXPORTER_SERVER_0_MONGODB_URI"); env != "" {
+		uri = env
+	}
+
+	if uri == "" {
+		uri = config.DefaultMongoDBURI
+	}
+
+	errs.Add("mongodb.uri", options.Client().ApplyURI(uri).Validate())
+
+	c := collector.New(collector.WithConfig(conf.collectorConfig()))
+	_ = c.RegisterServer(config.DefaultServerName, &collector.MongoDBDriver{})
+
+	for i, metric := range conf.Metrics {
+		errs.Add(fmt.Sprintf("metrics[%d]", i), c.RegisterAggregation(metric.aggregation()))
+	}
+
+	return errs.Err()
+}
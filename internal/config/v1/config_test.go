@@ -55,4 +55,20 @@ func TestBuild(t *testing.T) {
 			t.Errorf("Expected conf.Collectors[0].MongoDB.URI to be mongodb://bar:27017 but is %s", conf.MongoDB.URI)
 		}
 	})
+
+	t.Run("Connection fingerprint only changes with the connection settings", func(t *testing.T) {
+		mongodb := MongoDB{URI: "mongodb://foo:27017", MaxConnections: 3}
+		changedInterval := mongodb
+		changedInterval.DefaultInterval = 10
+		changedURI := mongodb
+		changedURI.URI = "mongodb://bar:27017"
+
+		if mongodb.fingerprint() != changedInterval.fingerprint() {
+			t.Error("Expected the fingerprint to be equal with the same connection settings")
+		}
+
+		if mongodb.fingerprint() == changedURI.fingerprint() {
+			t.Error("Expected the fingerprint to change with the URI")
+		}
+	})
 }
@@ -2,6 +2,7 @@ package v2
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -58,11 +59,90 @@ type Server struct {
 	URI  string
 }
 
+// The connection URI, MDBEXPORTER_SERVER_%d_MONGODB_URI takes precedence over the server config
+func (srv *Server) resolveURI(id int) string {
+	uri := srv.URI
+	if env := os.Getenv(fmt.Sprintf("MDBEXPORTER_SERVER_%d_MONGODB_URI", id)); env != "" {
+		uri = env
+	}
+
+	if uri == "" {
+		uri = config.DefaultMongoDBURI
+	}
+
+	return os.ExpandEnv(uri)
+}
+
+// The fingerprint of the server configuration, a reloaded config reuses the connection if it did not change.
+// The URI must have been resolved.
+func (srv *Server) fingerprint(global Global) string {
+	b, _ := json.Marshal(struct {
+		URI            string
+		MaxConnections int32
+	}{srv.URI, global.MaxConnections})
+
+	return string(b)
+}
+
+// The name of the server, defaults to its hosts
+func (srv *Server) serverName(opts *options.ClientOptions) string {
+	if srv.Name == "" {
+		return strings.Join(opts.Hosts, ",")
+	}
+
+	return srv.Name
+}
+
+// Convert the metric into a collector aggregation with a single metric
+func (metric *Metric) aggregation() *collector.Aggregation {
+	return &collector.Aggregation{
+		Servers:    metric.Servers,
+		Cache:      config.LegacyCache(metric.Cache),
+		Mode:       metric.Mode,
+		Database:   metric.Database,
+		Collection: metric.Collection,
+		Pipeline:   metric.Pipeline,
+		Metrics: []*collector.Metric{
+			{
+				Name:          metric.Name,
+				Type:          metric.Type,
+				Help:          metric.Help,
+				Value:         metric.Value,
+				OverrideEmpty: metric.OverrideEmpty,
+				EmptyValue:    metric.EmptyValue,
+				ConstLabels:   metric.ConstLabels,
+				Labels:        metric.Labels,
+			},
+		},
+	}
+}
+
+// The collector settings derived from the global config
+func (conf *Config) collectorConfig() *collector.Config {
+	return &collector.Config{
+		QueryTimeout:      conf.Global.QueryTimeout,
+		DefaultCache:      time.Duration(conf.Global.DefaultCache) * time.Second,
+		DefaultMode:       conf.Global.DefaultMode,
+		DefaultDatabase:   conf.Global.DefaultDatabase,
+		DefaultCollection: conf.Global.DefaultCollection,
+	}
+}
+
 // Get address where the http server should be bound to
 func (conf *Config) GetBindAddr() string {
 	return conf.Bind
 }
 
+// Web config is not supported, use --web.config.file instead
+func (conf *Config) GetWeb() *config.Web {
+	return nil
+}
+
+// Additional metrics paths are not supported
+func (conf *Config) GetMetricsPaths() []config.MetricsPath {
+	return nil
+}
+
 // Get metrics path
 func (conf *Config) GetMetricsPath() string {
 	return conf.MetricsPath
@@ -86,8 +166,8 @@ func (conf *Config) Build() (*collector.Collector, error) {
 
 	if conf.MetricsPath == "" {
 		conf.MetricsPath = config.DefaultMetricsPath
-	} else if conf.MetricsPath == config.HealthzPath {
-		return nil, fmt.Errorf("%s not allowed as metrics path", config.HealthzPath)
+	} else if config.IsReservedPath(conf.MetricsPath) {
+		return nil, fmt.Errorf("%s not allowed as metrics path", conf.MetricsPath)
 	}
 
 	if conf.Bind == "" {
@@ -106,47 +186,29 @@ func (conf *Config) Build() (*collector.Collector, error) {
 		})
 	}
 
-	config.Counter.Reset()
 	c := collector.New(
-		collector.WithConfig(&collector.Config{
-			QueryTimeout:      conf.Global.QueryTimeout,
-			DefaultCache:      time.Duration(conf.Global.DefaultCache) * time.Second,
-			DefaultMode:       conf.Global.DefaultMode,
-			DefaultDatabase:   conf.Global.DefaultDatabase,
-			DefaultCollection: conf.Global.DefaultCollection,
-		}),
+		collector.WithConfig(conf.collectorConfig()),
 		collector.WithLogger(l.Sugar()),
 		collector.WithCounter(config.Counter),
+		collector.WithServerUp(config.ServerUp),
 	)
 
 	for id, srv := range conf.Servers {
-		env := os.Getenv(fmt.Sprintf("MDBEXPORTER_SERVER_%d_MONGODB_URI", id))
-
-		if env != "" {
-			srv.URI = env
-		}
-
-		if srv.URI == "" {
-			srv.URI = config.DefaultMongoDBURI
-		}
-
-		srv.URI = os.ExpandEnv(srv.URI)
+		srv.URI = srv.resolveURI(id)
 		opts := options.Client().ApplyURI(srv.URI)
 		l.Sugar().Infof("use mongodb hosts %#v", opts.Hosts)
 
-		var err error
-		name := srv.Name
-		if name == "" {
-			name = strings.Join(opts.Hosts, ",")
+		if conf.Global.MaxConnections > 0 && opts.MaxPoolSize == nil {
+			opts.SetMaxPoolSize(uint64(conf.Global.MaxConnections))
 		}
 
 		d := &collector.MongoDBDriver{}
-		err = d.Connect(context.TODO(), opts)
-		if err != nil {
-			panic(err)
-		}
-
-		err = c.RegisterServer(name, d)
+		err := c.RegisterServer(srv.serverName(opts), d,
+			collector.WithFingerprint(srv.fingerprint(conf.Global)),
+			collector.WithConnect(func(ctx context.Context) error {
+				return d.Connect(ctx, opts)
+			}),
+		)
 		if err != nil {
 			return c, err
 		}
@@ -157,27 +219,7 @@ func (conf *Config) Build() (*collector.Collector, error) {
 	}
 
 	for _, metric := range conf.Metrics {
-		err := c.RegisterAggregation(&collector.Aggregation{
-			Servers:    metric.Servers,
-			Cache:      time.Duration(metric.Cache) * time.Second,
-			Mode:       metric.Mode,
-			Database:   metric.Database,
-			Collection: metric.Collection,
-			Pipeline:   metric.Pipeline,
-			Metrics: []*collector.Metric{
-				{
-					Name:          metric.Name,
-					Type:          metric.Type,
-					Help:          metric.Help,
-					Value:         metric.Value,
-					OverrideEmpty: metric.OverrideEmpty,
-					EmptyValue:    metric.EmptyValue,
-					ConstLabels:   metric.ConstLabels,
-					Labels:        metric.Labels,
-				},
-			},
-		})
-
+		err := c.RegisterAggregation(metric.aggregation())
 		if err != nil {
 			return c, err
 		}
@@ -185,3 +227,49 @@ func (conf *Config) Build() (*collector.Collector, error) {
 
 	return c, nil
 }
+
+// Run all checks of Build without connecting to any server.
+// All errors are returned with the location of the invalid setting, the config does not reference any files.
+func (conf *Config) Validate(opts config.ValidateOptions) error {
+	var errs config.ValidationErrors
+
+	log := conf.Log
+	if log.Encoding == "" {
+		log.Encoding = config.DefaultLogEncoder
+	}
+
+	if log.Level == "" {
+		log.Level = config.DefaultLogLevel
+	}
+
+	if _, err := zap.New(log); err != nil {
+		errs.Add("log", err)
+	}
+
+	if config.IsReservedPath(conf.MetricsPath) {
+		errs.Add("metricsPath", fmt.Errorf("%s not allowed as metrics path", conf.MetricsPath))
+	}
+
+	servers := conf.Servers
+	if len(servers) == 0 {
+		servers = []*Server{{Name: config.DefaultServerName}}
+	}
+
+	c := collector.New(collector.WithConfig(conf.collectorConfig()))
+	for id, srv := range servers {
+		location := fmt.Sprintf("servers[%d]", id)
+		opts := options.Client().ApplyURI(srv.resolveURI(id))
+		if err := opts.Validate(); err != nil {
+			errs.Add(location, err)
+			continue
+		}
+
+		errs.Add(location, c.RegisterServer(srv.serverName(opts), &collector.MongoDBDriver{}))
+	}
+
+	for i, metric := range conf.Metrics {
+		errs.Add(fmt.Sprintf("metrics[%d]", i), c.RegisterAggregation(metric.aggregation()))
+	}
+
+	return errs.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
 	"github.com/raffis/mongodb-query-exporter/v5/internal/x/zap"
 	"github.com/tj/assert"
 )
@@ -107,4 +108,33 @@ func TestBuild(t *testing.T) {
 		assert.Equal(t, conf.Servers[0].URI, "mongodb://bar:27017", "Expected conf.Collectors[0].MongoDB.URI to be mongodb://bar:27017")
 		assert.Equal(t, conf.Servers[1].URI, "mongodb://bar2:27017", "Expected conf.Collectors[0].MongoDB.URI to be mongodb://bar2:27017")
 	})
+
+	t.Run("Server fingerprint only changes with the connection settings", func(t *testing.T) {
+		srv := &Server{Name: "main", URI: "mongodb://foo:27017"}
+		global := Global{MaxConnections: 3}
+
+		assert.Equal(t, srv.fingerprint(global), (&Server{Name: "main", URI: "mongodb://foo:27017"}).fingerprint(Global{MaxConnections: 3, DefaultCache: 10}))
+		assert.NotEqual(t, srv.fingerprint(global), (&Server{Name: "main", URI: "mongodb://bar:27017"}).fingerprint(global))
+		assert.NotEqual(t, srv.fingerprint(global), srv.fingerprint(Global{MaxConnections: 5}))
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("All errors are reported with their location", func(t *testing.T) {
+		t.Setenv("MDBEXPORTER_SERVER_0_MONGODB_URI", "foo://bar")
+		var conf = &Config{
+			Metrics: []*Metric{
+				{Name: "foo-bar", Pipeline: "[]"},
+				{Name: "total", Pipeline: "[{"},
+				{Name: "total", Pipeline: "[]"},
+			},
+		}
+
+		err := conf.Validate(config.ValidateOptions{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "servers[0]: ")
+		assert.Contains(t, err.Error(), `metrics[0]: invalid metric foo-bar: invalid metric name "foo-bar"`)
+		assert.Contains(t, err.Error(), "metrics[1]: failed to decode json aggregation pipeline")
+		assert.NotContains(t, err.Error(), "metrics[2]")
+	})
 }
@@ -2,6 +2,7 @@ package v3
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -10,58 +11,254 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
 	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/x/tlsconfig"
 	"github.com/raffis/mongodb-query-exporter/v5/internal/x/zap"
 
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
 )
 
 // Configuration v3.0 format
 type Config struct {
-	Bind         string
-	MetricsPath  string
-	Log          zap.Config
-	Global       Global
-	Servers      []*Server
-	Aggregations []*collector.Aggregation
+	Bind         string         `yaml:"bind,omitempty"`
+	MetricsPath  string         `yaml:"metricsPath,omitempty"`
+	MetricsPaths []MetricsPath  `yaml:"metricsPaths,omitempty"`
+	Web          *config.Web    `yaml:"web,omitempty"`
+	Log          zap.Config     `yaml:"log,omitempty"`
+	Global       Global         `yaml:"global,omitempty"`
+	Servers      []*Server      `yaml:"servers,omitempty"`
+	Templates    []*Template    `yaml:"templates,omitempty"`
+	Aggregations []*Aggregation `yaml:"aggregations,omitempty"`
+	Probe        Probe          `yaml:"probe,omitempty"`
+}
+
+// An additional metrics path which only serves the listed aggregations and groups
+type MetricsPath struct {
+	Path         string   `yaml:"path,omitempty"`
+	Aggregations []string `yaml:"aggregations,omitempty"`
+	Groups       []string `yaml:"groups,omitempty"`
+}
+
+// Probe config for targets which are not configured as server
+type Probe struct {
+	// Glob patterns (like *.example.com:27017) of hosts which may be probed
+	AllowedHosts []string `yaml:"allowedHosts,omitempty"`
+	// Client options applied to probed targets, the URI is replaced by the target
+	Server Server `yaml:"server,omitempty"`
 }
 
 // Global config
 type Global struct {
-	QueryTimeout      time.Duration
-	MaxConnections    int32
-	DefaultCache      time.Duration
-	DefaultMode       string
-	DefaultDatabase   string
-	DefaultCollection string
+	QueryTimeout      time.Duration `yaml:"queryTimeout,omitempty"`
+	MaxConnections    int32         `yaml:"maxConnections,omitempty"`
+	DefaultCache      time.Duration `yaml:"defaultCache,omitempty"`
+	DefaultMode       string        `yaml:"defaultMode,omitempty"`
+	DefaultDatabase   string        `yaml:"defaultDatabase,omitempty"`
+	DefaultCollection string        `yaml:"defaultCollection,omitempty"`
 }
 
-// Aggregation defines what aggregation pipeline is executed on what servers
+// Aggregation defines what aggregation pipeline (or database command) is executed on what servers
 type Aggregation struct {
-	Servers    []string
-	Cache      time.Duration
-	Mode       string
-	Database   string
-	Collection string
-	Pipeline   string
-	Metrics    []Metric
+	Name              string             `yaml:"name,omitempty"`
+	Servers           []string           `yaml:"servers,omitempty"`
+	Cache             *time.Duration     `yaml:"cache,omitempty"`
+	Mode              string             `yaml:"mode,omitempty"`
+	Kind              string             `yaml:"kind,omitempty"`
+	Database          string             `yaml:"database,omitempty"`
+	Collection        string             `yaml:"collection,omitempty"`
+	DatabasePattern   string             `yaml:"databasePattern,omitempty"`
+	CollectionPattern string             `yaml:"collectionPattern,omitempty"`
+	DiscoveryInterval time.Duration      `yaml:"discoveryInterval,omitempty"`
+	Pipeline          string             `yaml:"pipeline,omitempty"`
+	Command           string             `yaml:"command,omitempty"`
+	ResultPath        string             `yaml:"resultPath,omitempty"`
+	Timeout           time.Duration      `yaml:"timeout,omitempty"`
+	ReadPreference    string             `yaml:"readPreference,omitempty"`
+	ReadConcern       string             `yaml:"readConcern,omitempty"`
+	AllowDiskUse      bool               `yaml:"allowDiskUse,omitempty"`
+	Hint              string             `yaml:"hint,omitempty"`
+	Collation         *options.Collation `yaml:"collation,omitempty"`
+	BatchSize         int32              `yaml:"batchSize,omitempty"`
+	Comment           string             `yaml:"comment,omitempty"`
+	Group             string             `yaml:"group,omitempty"`
+	Metrics           []Metric           `yaml:"metrics,omitempty"`
+	// Instantiate the template with this name, settings of the aggregation override the template
+	Template string            `yaml:"template,omitempty"`
+	Params   map[string]string `yaml:"params,omitempty"`
 }
 
 // Metric defines how a certain value is exported from a MongoDB aggregation
 type Metric struct {
-	Name          string
-	Type          string
-	Help          string
-	Value         string
-	OverrideEmpty bool
-	EmptyValue    int64
-	ConstLabels   prometheus.Labels
-	Labels        []string
+	Name          string            `yaml:"name,omitempty"`
+	Type          string            `yaml:"type,omitempty"`
+	Help          string            `yaml:"help,omitempty"`
+	Value         string            `yaml:"value,omitempty"`
+	OverrideEmpty bool              `yaml:"overrideEmpty,omitempty"`
+	EmptyValue    int64             `yaml:"emptyValue,omitempty"`
+	ConstLabels   prometheus.Labels `yaml:"constLabels,omitempty"`
+	Labels        []string          `yaml:"labels,omitempty"`
 }
 
 // MongoDB client options
 type Server struct {
-	Name string
-	URI  string
+	Name                   string              `yaml:"name,omitempty"`
+	URI                    string              `yaml:"uri,omitempty"`
+	URIFile                string              `yaml:"uriFile,omitempty"`
+	UsernameFile           string              `yaml:"usernameFile,omitempty"`
+	PasswordFile           string              `yaml:"passwordFile,omitempty"`
+	AppName                string              `yaml:"appName,omitempty"`
+	ReadPreference         string              `yaml:"readPreference,omitempty"`
+	ReadPreferenceTags     []map[string]string `yaml:"readPreferenceTags,omitempty"`
+	ReadConcern            string              `yaml:"readConcern,omitempty"`
+	MaxPoolSize            uint64              `yaml:"maxPoolSize,omitempty"`
+	MinPoolSize            uint64              `yaml:"minPoolSize,omitempty"`
+	MaxConnIdleTime        time.Duration       `yaml:"maxConnIdleTime,omitempty"`
+	ConnectTimeout         time.Duration       `yaml:"connectTimeout,omitempty"`
+	ServerSelectionTimeout time.Duration       `yaml:"serverSelectionTimeout,omitempty"`
+	Compressors            []string            `yaml:"compressors,omitempty"`
+	DirectConnection       *bool               `yaml:"directConnection,omitempty"`
+	TLS                    *tlsconfig.Config   `yaml:"tls,omitempty"`
+}
+
+// The fingerprint of the server configuration, a reloaded config reuses the connection if it did not change
+func (srv *Server) fingerprint(global Global) string {
+	b, _ := json.Marshal(struct {
+		Server         *Server
+		URI            string
+		MaxConnections int32
+	}{srv, os.ExpandEnv(srv.URI), global.MaxConnections})
+
+	return string(b)
+}
+
+// The name of the server, defaults to its hosts
+func (srv *Server) serverName(opts *options.ClientOptions) string {
+	if srv.Name == "" {
+		return strings.Join(opts.Hosts, ",")
+	}
+
+	return srv.Name
+}
+
+// Apply the server options on top of the options parsed from the URI.
+// Options which are not set keep the value from the URI.
+func (srv *Server) applyOptions(opts *options.ClientOptions, global Global) error {
+	if srv.AppName != "" {
+		opts.SetAppName(srv.AppName)
+	}
+
+	if srv.ReadPreference != "" {
+		rp, err := collector.ParseReadPreference(srv.ReadPreference, readpref.WithTagSets(tag.NewTagSetsFromMaps(srv.ReadPreferenceTags)...))
+		if err != nil {
+			return fmt.Errorf("invalid read preference: %w", err)
+		}
+
+		opts.SetReadPreference(rp)
+	} else if len(srv.ReadPreferenceTags) > 0 {
+		return fmt.Errorf("read preference tags require a read preference")
+	}
+
+	if srv.ReadConcern != "" {
+		rc, err := collector.ParseReadConcern(srv.ReadConcern)
+		if err != nil {
+			return fmt.Errorf("invalid read concern: %w", err)
+		}
+
+		opts.SetReadConcern(rc)
+	}
+
+	if srv.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(srv.MaxPoolSize)
+	} else if global.MaxConnections > 0 && opts.MaxPoolSize == nil {
+		opts.SetMaxPoolSize(uint64(global.MaxConnections))
+	}
+
+	if srv.MinPoolSize > 0 {
+		opts.SetMinPoolSize(srv.MinPoolSize)
+	}
+
+	if srv.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(srv.MaxConnIdleTime)
+	}
+
+	if srv.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(srv.ConnectTimeout)
+	}
+
+	if srv.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(srv.ServerSelectionTimeout)
+	}
+
+	for _, compressor := range srv.Compressors {
+		switch compressor {
+		case "snappy", "zlib", "zstd":
+		default:
+			return fmt.Errorf("unknown compressor %s provided. Only [snappy, zlib, zstd] are valid options", compressor)
+		}
+	}
+
+	if len(srv.Compressors) > 0 {
+		opts.SetCompressors(srv.Compressors)
+	}
+
+	if srv.DirectConnection != nil {
+		opts.SetDirect(*srv.DirectConnection)
+	}
+
+	if srv.TLS != nil {
+		tlsConfig, err := tlsconfig.New(*srv.TLS)
+		if err != nil {
+			return fmt.Errorf("invalid tls config: %w", err)
+		}
+
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts.Validate()
+}
+
+// Convert the aggregation into a collector aggregation
+func (aggregation *Aggregation) build() *collector.Aggregation {
+	opts := &collector.Aggregation{
+		Name:              aggregation.Name,
+		Servers:           aggregation.Servers,
+		Cache:             aggregation.Cache,
+		Mode:              aggregation.Mode,
+		Kind:              aggregation.Kind,
+		Database:          aggregation.Database,
+		Collection:        aggregation.Collection,
+		DatabasePattern:   aggregation.DatabasePattern,
+		CollectionPattern: aggregation.CollectionPattern,
+		DiscoveryInterval: aggregation.DiscoveryInterval,
+		Pipeline:          aggregation.Pipeline,
+		Command:           aggregation.Command,
+		ResultPath:        aggregation.ResultPath,
+		Timeout:           aggregation.Timeout,
+		ReadPreference:    aggregation.ReadPreference,
+		ReadConcern:       aggregation.ReadConcern,
+		AllowDiskUse:      aggregation.AllowDiskUse,
+		Hint:              aggregation.Hint,
+		Collation:         aggregation.Collation,
+		BatchSize:         aggregation.BatchSize,
+		Comment:           aggregation.Comment,
+		Group:             aggregation.Group,
+	}
+
+	for _, metric := range aggregation.Metrics {
+		opts.Metrics = append(opts.Metrics, &collector.Metric{
+			Name:          metric.Name,
+			Type:          metric.Type,
+			Help:          metric.Help,
+			Value:         metric.Value,
+			OverrideEmpty: metric.OverrideEmpty,
+			EmptyValue:    metric.EmptyValue,
+			ConstLabels:   metric.ConstLabels,
+			Labels:        metric.Labels,
+		})
+	}
+
+	return opts
 }
 
 // Get address where the http server should be bound to
@@ -74,6 +271,81 @@ func (conf *Config) GetMetricsPath() string {
 	return conf.MetricsPath
 }
 
+// Get TLS and basic auth settings of the http server
+func (conf *Config) GetWeb() *config.Web {
+	return conf.Web
+}
+
+// Get additional metrics paths
+func (conf *Config) GetMetricsPaths() []config.MetricsPath {
+	var paths []config.MetricsPath
+	for _, path := range conf.MetricsPaths {
+		paths = append(paths, config.MetricsPath{
+			Path:         path.Path,
+			Aggregations: path.Aggregations,
+			Groups:       path.Groups,
+		})
+	}
+
+	return paths
+}
+
+// Validate the additional metrics paths, they must be unique and may only reference existing aggregations and groups
+func (conf *Config) validateMetricsPaths(errs *config.ValidationErrors, aggregations []*Aggregation) {
+	names := make(map[string]bool)
+	groups := make(map[string]bool)
+	for i, aggregation := range aggregations {
+		if aggregation == nil {
+			continue
+		}
+
+		// Unnamed aggregations are referenced by their position like in the query counter
+		name := aggregation.Name
+		if name == "" {
+			name = fmt.Sprintf("aggregation_%d", i)
+		}
+
+		names[name] = true
+		groups[aggregation.Group] = true
+	}
+
+	paths := map[string]bool{
+		conf.MetricsPath: true,
+	}
+
+	for i, path := range conf.MetricsPaths {
+		location := fmt.Sprintf("metricsPaths[%d]", i)
+		if path.Path == "" || paths[path.Path] || config.IsReservedPath(path.Path) {
+			errs.Add(location, fmt.Errorf("metrics path %q is not allowed or already in use", path.Path))
+		}
+
+		paths[path.Path] = true
+
+		for _, name := range path.Aggregations {
+			if name == "" || !names[name] {
+				errs.Add(location, fmt.Errorf("metrics path %s references unknown aggregation %s", path.Path, name))
+			}
+		}
+
+		for _, group := range path.Groups {
+			if group == "" || !groups[group] {
+				errs.Add(location, fmt.Errorf("metrics path %s references unknown group %s", path.Path, group))
+			}
+		}
+	}
+}
+
+// The collector settings derived from the global config
+func (conf *Config) collectorConfig() *collector.Config {
+	return &collector.Config{
+		QueryTimeout:      conf.Global.QueryTimeout,
+		DefaultCache:      conf.Global.DefaultCache,
+		DefaultMode:       conf.Global.DefaultMode,
+		DefaultDatabase:   conf.Global.DefaultDatabase,
+		DefaultCollection: conf.Global.DefaultCollection,
+	}
+}
+
 // Build collectors from a configuration v2.0 format and return a collection of
 // all configured collectors
 func (conf *Config) Build() (*collector.Collector, error) {
@@ -92,8 +364,17 @@ func (conf *Config) Build() (*collector.Collector, error) {
 
 	if conf.MetricsPath == "" {
 		conf.MetricsPath = config.DefaultMetricsPath
-	} else if conf.MetricsPath == config.HealthzPath {
-		return nil, fmt.Errorf("%s not allowed as metrics path", config.HealthzPath)
+	} else if config.IsReservedPath(conf.MetricsPath) {
+		return nil, fmt.Errorf("%s not allowed as metrics path", conf.MetricsPath)
+	}
+
+	var errs config.ValidationErrors
+	if conf.Aggregations = conf.instantiateTemplates(&errs); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	if conf.validateMetricsPaths(&errs, conf.Aggregations); len(errs) > 0 {
+		return nil, errs[0].Err
 	}
 
 	if conf.Bind == "" {
@@ -112,47 +393,50 @@ func (conf *Config) Build() (*collector.Collector, error) {
 		})
 	}
 
-	config.Counter.Reset()
 	c := collector.New(
-		collector.WithConfig(&collector.Config{
-			QueryTimeout:      conf.Global.QueryTimeout,
-			DefaultCache:      conf.Global.DefaultCache,
-			DefaultMode:       conf.Global.DefaultMode,
-			DefaultDatabase:   conf.Global.DefaultDatabase,
-			DefaultCollection: conf.Global.DefaultCollection,
-		}),
+		collector.WithConfig(conf.collectorConfig()),
 		collector.WithLogger(l.Sugar()),
 		collector.WithCounter(config.Counter),
+		collector.WithServerUp(config.ServerUp),
+		collector.WithTargetConnector(conf.connectTarget),
 	)
 
 	for id, srv := range conf.Servers {
-		env := os.Getenv(fmt.Sprintf("MDBEXPORTER_SERVER_%d_MONGODB_URI", id))
-
-		if env != "" {
-			srv.URI = env
+		err := srv.resolveURI(id)
+		if err != nil {
+			return c, fmt.Errorf("invalid options for server %d: %w", id, err)
 		}
 
-		if srv.URI == "" {
-			srv.URI = config.DefaultMongoDBURI
+		opts, err := srv.clientOptions(conf.Global)
+		if err != nil {
+			return c, fmt.Errorf("invalid options for server %d: %w", id, err)
 		}
 
-		srv.URI = os.ExpandEnv(srv.URI)
-		opts := options.Client().ApplyURI(srv.URI)
 		l.Sugar().Infof("use mongodb hosts %#v", opts.Hosts)
+		name := srv.serverName(opts)
 
-		var err error
-		name := srv.Name
-		if name == "" {
-			name = strings.Join(opts.Hosts, ",")
+		// Options are rebuilt on each connection attempt to pick up changed secret files
+		srv := srv
+		d := &collector.MongoDBDriver{}
+		serverOpts := []collector.ServerOption{
+			collector.WithFingerprint(srv.fingerprint(conf.Global)),
+			collector.WithConnect(func(ctx context.Context) error {
+				opts, err := srv.clientOptions(conf.Global)
+				if err != nil {
+					return err
+				}
+
+				return d.Connect(ctx, opts)
+			}),
 		}
 
-		d := &collector.MongoDBDriver{}
-		err = d.Connect(context.TODO(), opts)
-		if err != nil {
-			panic(err)
+		if len(srv.secretFiles()) > 0 {
+			serverOpts = append(serverOpts, collector.WithWatch(func(ctx context.Context, driver collector.Driver) {
+				srv.watchSecretFiles(ctx, name, conf.Global, driver, l.Sugar(), config.SecretFilesReloadInterval)
+			}))
 		}
 
-		err = c.RegisterServer(name, d)
+		err = c.RegisterServer(name, d, serverOpts...)
 		if err != nil {
 			return c, err
 		}
@@ -163,33 +447,12 @@ func (conf *Config) Build() (*collector.Collector, error) {
 	}
 
 	for i, aggregation := range conf.Aggregations {
-		opts := &collector.Aggregation{
-			Servers:    aggregation.Servers,
-			Cache:      aggregation.Cache,
-			Mode:       aggregation.Mode,
-			Database:   aggregation.Database,
-			Collection: aggregation.Collection,
-			Pipeline:   aggregation.Pipeline,
-		}
-
-		for _, metric := range aggregation.Metrics {
-			opts.Metrics = append(opts.Metrics, &collector.Metric{
-				Name:          metric.Name,
-				Type:          metric.Type,
-				Help:          metric.Help,
-				Value:         metric.Value,
-				OverrideEmpty: metric.OverrideEmpty,
-				EmptyValue:    metric.EmptyValue,
-				ConstLabels:   metric.ConstLabels,
-				Labels:        metric.Labels,
-			})
-		}
-
-		if len(conf.Aggregations) == 0 {
-			l.Sugar().Warn("no metrics have been configured for aggregation_%d", i)
+		opts := aggregation.build()
+		if len(aggregation.Metrics) == 0 {
+			l.Sugar().Warnf("no metrics have been configured for aggregation_%d", i)
 		}
 
-		err := c.RegisterAggregation(aggregation)
+		err := c.RegisterAggregation(opts)
 		if err != nil {
 			return c, err
 		}
@@ -1,13 +1,194 @@
 package v3
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/x/tlsconfig"
 	"github.com/raffis/mongodb-query-exporter/v5/internal/x/zap"
 	"github.com/tj/assert"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+func TestServerOptions(t *testing.T) {
+	t.Run("Server options are applied on top of the URI options", func(t *testing.T) {
+		direct := true
+		srv := &Server{
+			AppName:                "exporter",
+			ReadPreference:         "secondary",
+			ReadPreferenceTags:     []map[string]string{{"nodeType": "ANALYTICS"}},
+			ReadConcern:            "majority",
+			MinPoolSize:            1,
+			MaxConnIdleTime:        time.Minute,
+			ConnectTimeout:         5 * time.Second,
+			ServerSelectionTimeout: 3 * time.Second,
+			Compressors:            []string{"zstd"},
+			DirectConnection:       &direct,
+		}
+
+		opts := options.Client().ApplyURI("mongodb://foo:27017/?maxPoolSize=5")
+		assert.NoError(t, srv.applyOptions(opts, Global{MaxConnections: 3}))
+
+		assert.Equal(t, "exporter", *opts.AppName)
+		assert.Equal(t, readpref.SecondaryMode, opts.ReadPreference.Mode())
+		assert.Len(t, opts.ReadPreference.TagSets(), 1)
+		assert.Equal(t, "majority", opts.ReadConcern.Level)
+		assert.Equal(t, uint64(5), *opts.MaxPoolSize)
+		assert.Equal(t, uint64(1), *opts.MinPoolSize)
+		assert.Equal(t, time.Minute, *opts.MaxConnIdleTime)
+		assert.Equal(t, 5*time.Second, *opts.ConnectTimeout)
+		assert.Equal(t, 3*time.Second, *opts.ServerSelectionTimeout)
+		assert.Equal(t, []string{"zstd"}, opts.Compressors)
+		assert.Equal(t, true, *opts.Direct)
+	})
+
+	t.Run("Global max connections is used as default max pool size", func(t *testing.T) {
+		srv := &Server{}
+		opts := options.Client().ApplyURI("mongodb://foo:27017")
+		assert.NoError(t, srv.applyOptions(opts, Global{MaxConnections: 3}))
+		assert.Equal(t, uint64(3), *opts.MaxPoolSize)
+	})
+
+	t.Run("Read preference tags without read preference fails", func(t *testing.T) {
+		srv := &Server{
+			ReadPreferenceTags: []map[string]string{{"nodeType": "ANALYTICS"}},
+		}
+
+		assert.Error(t, srv.applyOptions(options.Client().ApplyURI("mongodb://foo:27017"), Global{}))
+	})
+
+	t.Run("Read preference tags with primary read preference fails", func(t *testing.T) {
+		srv := &Server{
+			ReadPreference:     "primary",
+			ReadPreferenceTags: []map[string]string{{"nodeType": "ANALYTICS"}},
+		}
+
+		assert.Error(t, srv.applyOptions(options.Client().ApplyURI("mongodb://foo:27017"), Global{}))
+	})
+
+	t.Run("Invalid compressor fails", func(t *testing.T) {
+		srv := &Server{
+			Compressors: []string{"foo"},
+		}
+
+		assert.Error(t, srv.applyOptions(options.Client().ApplyURI("mongodb://foo:27017"), Global{}))
+	})
+
+	t.Run("TLS config is applied", func(t *testing.T) {
+		srv := &Server{
+			TLS: &tlsconfig.Config{
+				ServerName: "mongodb.example.com",
+			},
+		}
+
+		opts := options.Client().ApplyURI("mongodb://foo:27017")
+		assert.NoError(t, srv.applyOptions(opts, Global{}))
+		assert.Equal(t, "mongodb.example.com", opts.TLSConfig.ServerName)
+	})
+
+	t.Run("TLS config with missing ca file fails", func(t *testing.T) {
+		srv := &Server{
+			TLS: &tlsconfig.Config{
+				CAFile: "/does/not/exist.pem",
+			},
+		}
+
+		assert.Error(t, srv.applyOptions(options.Client().ApplyURI("mongodb://foo:27017"), Global{}))
+	})
+}
+
+func TestSecretFiles(t *testing.T) {
+	writeFile := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "secret")
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	t.Run("URI is read from file", func(t *testing.T) {
+		srv := &Server{
+			URIFile: writeFile(t, "mongodb://foo:27017\n"),
+		}
+
+		assert.NoError(t, srv.resolveURI(9))
+		opts, err := srv.clientOptions(Global{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"foo:27017"}, opts.Hosts)
+	})
+
+	t.Run("URI file is overwriteable by env", func(t *testing.T) {
+		t.Setenv("MDBEXPORTER_SERVER_9_MONGODB_URI_FILE", writeFile(t, "mongodb://bar:27017"))
+		srv := &Server{
+			URI: "mongodb://foo:27017",
+		}
+
+		assert.NoError(t, srv.resolveURI(9))
+		assert.Equal(t, "", srv.URI)
+		opts, err := srv.clientOptions(Global{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"bar:27017"}, opts.Hosts)
+	})
+
+	t.Run("Credentials are read from files", func(t *testing.T) {
+		srv := &Server{
+			URI:          "mongodb://foo:27017/?authSource=admin",
+			UsernameFile: writeFile(t, "user"),
+			PasswordFile: writeFile(t, "secret"),
+		}
+
+		assert.NoError(t, srv.resolveURI(9))
+		opts, err := srv.clientOptions(Global{})
+		assert.NoError(t, err)
+		assert.Equal(t, "user", opts.Auth.Username)
+		assert.Equal(t, "secret", opts.Auth.Password)
+		assert.Equal(t, "admin", opts.Auth.AuthSource)
+	})
+
+	t.Run("Changed secret files are detected", func(t *testing.T) {
+		srv := &Server{
+			UsernameFile: writeFile(t, "user"),
+			PasswordFile: writeFile(t, "secret"),
+		}
+
+		before, err := srv.readSecretFiles()
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(srv.PasswordFile, []byte("rotated"), 0600))
+		after, err := srv.readSecretFiles()
+		assert.NoError(t, err)
+		assert.NotEqual(t, before, after)
+	})
+
+	t.Run("URI and URI file are mutually exclusive", func(t *testing.T) {
+		srv := &Server{
+			URI:     "mongodb://foo:27017",
+			URIFile: "/does/not/exist",
+		}
+
+		assert.Error(t, srv.resolveURI(9))
+	})
+
+	t.Run("Username file without password file fails", func(t *testing.T) {
+		srv := &Server{
+			UsernameFile: "/does/not/exist",
+		}
+
+		assert.Error(t, srv.resolveURI(9))
+	})
+
+	t.Run("Missing URI file fails", func(t *testing.T) {
+		srv := &Server{
+			URIFile: "/does/not/exist",
+		}
+
+		_, err := srv.clientOptions(Global{})
+		assert.Error(t, err)
+	})
+}
+
 func TestBuild(t *testing.T) {
 	t.Run("Build collector", func(t *testing.T) {
 		var conf = &Config{}
@@ -107,4 +288,420 @@ func TestBuild(t *testing.T) {
 		assert.Equal(t, conf.Servers[0].URI, "mongodb://bar:27017", "Expected conf.Collectors[0].MongoDB.URI to be mongodb://bar:27017")
 		assert.Equal(t, conf.Servers[1].URI, "mongodb://bar2:27017", "Expected conf.Collectors[0].MongoDB.URI to be mongodb://bar2:27017")
 	})
+
+	t.Run("Aggregation with a database command is registered", func(t *testing.T) {
+		var conf = &Config{
+			Log: zap.Config{
+				Encoding: "console",
+				Level:    "error",
+			},
+			Aggregations: []*Aggregation{
+				{
+					Kind:       "command",
+					Command:    `{"replSetGetStatus": 1}`,
+					ResultPath: "members",
+					Metrics: []Metric{
+						{
+							Name:   "mongodb_member_health",
+							Value:  "health",
+							Labels: []string{"name"},
+						},
+					},
+				},
+			},
+		}
+
+		_, err := conf.Build()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Aggregation with an invalid database command fails", func(t *testing.T) {
+		var conf = &Config{
+			Log: zap.Config{
+				Encoding: "console",
+				Level:    "error",
+			},
+			Aggregations: []*Aggregation{
+				{
+					Kind:    "command",
+					Command: `{`,
+				},
+			},
+		}
+
+		_, err := conf.Build()
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid server read preference fails", func(t *testing.T) {
+		var conf = &Config{
+			Log: zap.Config{
+				Encoding: "console",
+				Level:    "error",
+			},
+			Servers: []*Server{
+				{
+					Name:           "foo",
+					URI:            "mongodb://foo:27017",
+					ReadPreference: "foo",
+				},
+			},
+		}
+
+		_, err := conf.Build()
+		assert.Error(t, err)
+	})
+}
+
+func TestProbe(t *testing.T) {
+	conf := &Config{
+		Probe: Probe{
+			AllowedHosts: []string{"*.example.com:27017"},
+		},
+	}
+
+	t.Run("Target with allowed hosts is connected", func(t *testing.T) {
+		name, d, err := conf.connectTarget(context.Background(), "mongodb://a.example.com:27017,b.example.com:27017/?replicaSet=rs0")
+		assert.NoError(t, err)
+		assert.Equal(t, "a.example.com:27017,b.example.com:27017", name)
+		assert.NoError(t, d.Disconnect(context.Background()))
+	})
+
+	t.Run("Target with a host which is not allowed fails", func(t *testing.T) {
+		_, _, err := conf.connectTarget(context.Background(), "mongodb://a.example.com:27017,evil.com:27017")
+		assert.EqualError(t, err, "target host evil.com:27017 is not allowed")
+	})
+
+	t.Run("Env variables in the target are not substituted", func(t *testing.T) {
+		t.Setenv("PROBE_HOST", "a.example.com:27017")
+		_, _, err := conf.connectTarget(context.Background(), "mongodb://${PROBE_HOST}")
+		assert.Error(t, err)
+	})
+}
+
+func TestMetricsPaths(t *testing.T) {
+	buildConfig := func(paths ...MetricsPath) *Config {
+		return &Config{
+			Log: zap.Config{
+				Encoding: "console",
+				Level:    "error",
+			},
+			MetricsPaths: paths,
+			Aggregations: []*Aggregation{
+				{
+					Name:     "users",
+					Group:    "slow",
+					Pipeline: "[]",
+				},
+			},
+		}
+	}
+
+	t.Run("Metrics paths are returned", func(t *testing.T) {
+		conf := buildConfig(MetricsPath{Path: "/metrics/slow", Groups: []string{"slow"}})
+		_, err := conf.Build()
+		assert.NoError(t, err)
+		assert.Equal(t, []config.MetricsPath{{Path: "/metrics/slow", Groups: []string{"slow"}}}, conf.GetMetricsPaths())
+	})
+
+	t.Run("Metrics path equal to the default metrics path fails", func(t *testing.T) {
+		_, err := buildConfig(MetricsPath{Path: "/metrics"}).Build()
+		assert.EqualError(t, err, `metrics path "/metrics" is not allowed or already in use`)
+	})
+
+	t.Run("Duplicate metrics path fails", func(t *testing.T) {
+		_, err := buildConfig(MetricsPath{Path: "/slow"}, MetricsPath{Path: "/slow"}).Build()
+		assert.EqualError(t, err, `metrics path "/slow" is not allowed or already in use`)
+	})
+
+	t.Run("Metrics path with unknown aggregation fails", func(t *testing.T) {
+		_, err := buildConfig(MetricsPath{Path: "/slow", Aggregations: []string{"foo"}}).Build()
+		assert.EqualError(t, err, "metrics path /slow references unknown aggregation foo")
+	})
+
+	t.Run("Unnamed aggregations are referenced by their position", func(t *testing.T) {
+		conf := buildConfig(MetricsPath{Path: "/slow", Aggregations: []string{"aggregation_1"}})
+		conf.Aggregations = append(conf.Aggregations, &Aggregation{Pipeline: "[]"})
+		_, err := conf.Build()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Metrics path with unknown group fails", func(t *testing.T) {
+		_, err := buildConfig(MetricsPath{Path: "/slow", Groups: []string{"foo"}}).Build()
+		assert.EqualError(t, err, "metrics path /slow references unknown group foo")
+	})
+}
+
+func TestWeb(t *testing.T) {
+	t.Run("Web config is written in the exporter-toolkit format", func(t *testing.T) {
+		conf := &Config{
+			Web: &config.Web{
+				TLSServerConfig: &config.WebTLS{
+					CertFile:       "/etc/tls/tls.crt",
+					KeyFile:        "/etc/tls/tls.key",
+					ClientAuthType: "RequireAndVerifyClientCert",
+					ClientCAFile:   "/etc/tls/ca.crt",
+				},
+				BasicAuthUsers: []config.BasicAuthUser{
+					{
+						Username: "Prometheus",
+						Password: "$2y$10$mDwo.lAisC94iLAyP81MCesa29IzH37oigHC/42V2pdJlUprsJPze",
+					},
+				},
+			},
+		}
+
+		path, err := conf.GetWeb().WriteFile()
+		assert.NoError(t, err)
+		defer os.Remove(path)
+
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"tls_server_config": {
+				"cert_file": "/etc/tls/tls.crt",
+				"key_file": "/etc/tls/tls.key",
+				"client_auth_type": "RequireAndVerifyClientCert",
+				"client_ca_file": "/etc/tls/ca.crt"
+			},
+			"basic_auth_users": {
+				"Prometheus": "$2y$10$mDwo.lAisC94iLAyP81MCesa29IzH37oigHC/42V2pdJlUprsJPze"
+			}
+		}`, string(b))
+	})
+
+	t.Run("No web config is returned if not configured", func(t *testing.T) {
+		conf := &Config{}
+		assert.Nil(t, conf.GetWeb())
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid config", func(t *testing.T) {
+		conf := &Config{
+			Aggregations: []*Aggregation{
+				{
+					Pipeline: "[]",
+					Metrics:  []Metric{{Name: "total", Value: "total"}},
+				},
+			},
+		}
+
+		assert.NoError(t, conf.Validate(config.ValidateOptions{}))
+	})
+
+	t.Run("All errors are reported with their location", func(t *testing.T) {
+		conf := &Config{
+			MetricsPath:  "/healthz",
+			MetricsPaths: []MetricsPath{{Path: "/slow", Groups: []string{"foo"}}},
+			Servers: []*Server{
+				{Name: "main"},
+				{Name: "main", URI: "mongodb://foo:27017", URIFile: "/uri"},
+			},
+			Aggregations: []*Aggregation{
+				{
+					Servers:  []string{"other"},
+					Pipeline: "[]",
+				},
+				{
+					Pipeline: "[{",
+					Metrics:  []Metric{{Name: "foo-bar"}, {Name: "total"}},
+				},
+			},
+		}
+
+		errs, ok := conf.Validate(config.ValidateOptions{}).(config.ValidationErrors)
+		assert.True(t, ok)
+
+		var locations []string
+		for _, err := range errs {
+			locations = append(locations, err.Path)
+		}
+
+		assert.Equal(t, []string{
+			"metricsPath",
+			"metricsPaths[0]",
+			"servers[1]",
+			"aggregations[0]",
+			"aggregations[1].metrics[0]",
+			"aggregations[1]",
+		}, locations)
+		assert.Equal(t, "aggregations[0]: aggregation bound to server other which has not been found", errs[3].Error())
+	})
+
+	t.Run("All errors of an aggregation are reported individually", func(t *testing.T) {
+		conf := &Config{
+			Aggregations: []*Aggregation{
+				{
+					Mode:     "foo",
+					Pipeline: "[{",
+				},
+			},
+		}
+
+		errs, ok := conf.Validate(config.ValidateOptions{}).(config.ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "aggregations[0]: unknown aggregation mode foo provided. Only [pull, push] are valid options", errs[0].Error())
+		assert.Equal(t, "aggregations[0]", errs[1].Path)
+		assert.Contains(t, errs[1].Error(), "failed to decode json aggregation pipeline")
+	})
+
+	t.Run("Config is not modified", func(t *testing.T) {
+		os.Setenv("MDBEXPORTER_SERVER_0_MONGODB_URI", "mongodb://env:27017")
+		defer os.Unsetenv("MDBEXPORTER_SERVER_0_MONGODB_URI")
+
+		conf := &Config{
+			Servers: []*Server{{Name: "main", URIFile: "/uri"}, {Name: "other"}},
+		}
+
+		assert.NoError(t, conf.Validate(config.ValidateOptions{}))
+		assert.Equal(t, &Server{Name: "main", URIFile: "/uri"}, conf.Servers[0])
+		assert.Equal(t, &Server{Name: "other"}, conf.Servers[1])
+	})
+
+	t.Run("Referenced files are only read if files are checked", func(t *testing.T) {
+		dir := t.TempDir()
+		conf := &Config{
+			Servers: []*Server{
+				{
+					URIFile:      filepath.Join(dir, "uri"),
+					UsernameFile: filepath.Join(dir, "username"),
+					PasswordFile: filepath.Join(dir, "password"),
+				},
+				{
+					Name: "tls",
+					TLS:  &tlsconfig.Config{CAFile: filepath.Join(dir, "ca.crt")},
+				},
+			},
+		}
+
+		assert.NoError(t, conf.Validate(config.ValidateOptions{}))
+
+		errs, ok := conf.Validate(config.ValidateOptions{CheckFiles: true}).(config.ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 2)
+		assert.Contains(t, errs[0].Error(), "servers[0]: failed to read secret file")
+		assert.Contains(t, errs[1].Error(), "servers[1]: invalid tls config: failed to read ca file")
+	})
+
+	t.Run("File settings are checked without reading the files", func(t *testing.T) {
+		conf := &Config{
+			Servers: []*Server{
+				{UsernameFile: "/username"},
+				{TLS: &tlsconfig.Config{CertFile: "/tls.crt"}},
+			},
+		}
+
+		errs, ok := conf.Validate(config.ValidateOptions{}).(config.ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "servers[0]: usernameFile and passwordFile must be set together", errs[0].Error())
+		assert.Equal(t, "servers[1]: invalid tls config: certFile and keyFile must be set together", errs[1].Error())
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	buildConfig := func(aggregations ...*Aggregation) *Config {
+		return &Config{
+			Log: zap.Config{
+				Encoding: "console",
+				Level:    "error",
+			},
+			Templates: []*Template{
+				{
+					Name:   "queue",
+					Params: map[string]string{"status": "waiting"},
+					Aggregation: Aggregation{
+						Database:   "{{ .tenant }}",
+						Collection: "jobs",
+						Pipeline:   `[{"$match":{"status":"{{ .status }}"}},{"$count":"total"}]`,
+						Metrics: []Metric{
+							{
+								Name:        "{{ .tenant }}_queue_total",
+								Value:       "total",
+								ConstLabels: map[string]string{"status": "{{ .status }}"},
+							},
+						},
+					},
+				},
+			},
+			Aggregations: aggregations,
+		}
+	}
+
+	t.Run("Aggregations are instantiated with params and template defaults", func(t *testing.T) {
+		conf := buildConfig(
+			&Aggregation{Template: "queue", Params: map[string]string{"tenant": "a"}},
+			&Aggregation{Template: "queue", Params: map[string]string{"tenant": "b", "status": "failed"}, Collection: "failed_jobs"},
+		)
+
+		var errs config.ValidationErrors
+		aggregations := conf.instantiateTemplates(&errs)
+		assert.NoError(t, errs.Err())
+		assert.Len(t, aggregations, 2)
+
+		assert.Equal(t, "a", aggregations[0].Database)
+		assert.Equal(t, "jobs", aggregations[0].Collection)
+		assert.Equal(t, `[{"$match":{"status":"waiting"}},{"$count":"total"}]`, aggregations[0].Pipeline)
+		assert.Equal(t, "a_queue_total", aggregations[0].Metrics[0].Name)
+		assert.Equal(t, "waiting", aggregations[0].Metrics[0].ConstLabels["status"])
+
+		assert.Equal(t, "b", aggregations[1].Database)
+		assert.Equal(t, "failed_jobs", aggregations[1].Collection)
+		assert.Equal(t, "b_queue_total", aggregations[1].Metrics[0].Name)
+		assert.Equal(t, "failed", aggregations[1].Metrics[0].ConstLabels["status"])
+
+		// The template itself is not modified
+		assert.Equal(t, "{{ .tenant }}_queue_total", conf.Templates[0].Aggregation.Metrics[0].Name)
+	})
+
+	t.Run("A cache of 0 overrides the cache of the template", func(t *testing.T) {
+		cache, disabled := time.Minute, time.Duration(0)
+		conf := buildConfig(
+			&Aggregation{Template: "queue", Params: map[string]string{"tenant": "a"}},
+			&Aggregation{Template: "queue", Params: map[string]string{"tenant": "b"}, Cache: &disabled},
+		)
+		conf.Templates[0].Aggregation.Cache = &cache
+
+		var errs config.ValidationErrors
+		aggregations := conf.instantiateTemplates(&errs)
+		assert.NoError(t, errs.Err())
+		assert.Equal(t, time.Minute, *aggregations[0].Cache)
+		assert.Equal(t, time.Duration(0), *aggregations[1].Cache)
+	})
+
+	t.Run("Instantiated aggregations are registered", func(t *testing.T) {
+		conf := buildConfig(
+			&Aggregation{Template: "queue", Params: map[string]string{"tenant": "a"}},
+			&Aggregation{Template: "queue", Params: map[string]string{"tenant": "b"}},
+		)
+
+		_, err := conf.Build()
+		assert.NoError(t, err)
+		assert.Len(t, conf.Aggregations, 2)
+		assert.Equal(t, "", conf.Aggregations[0].Template)
+	})
+
+	t.Run("Unknown template and missing params are reported with their location", func(t *testing.T) {
+		conf := buildConfig(
+			&Aggregation{Template: "foo"},
+			&Aggregation{Template: "queue"},
+		)
+
+		errs, ok := conf.Validate(config.ValidateOptions{}).(config.ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "aggregations[0]: unknown template foo", errs[0].Error())
+		assert.Equal(t, "aggregations[1]", errs[1].Path)
+		assert.Contains(t, errs[1].Error(), `map has no entry for key "tenant"`)
+	})
+
+	t.Run("Duplicate template fails", func(t *testing.T) {
+		conf := buildConfig()
+		conf.Templates = append(conf.Templates, &Template{Name: "queue"})
+
+		_, err := conf.Build()
+		assert.EqualError(t, err, "templates[1]: template queue is already defined")
+	})
 }
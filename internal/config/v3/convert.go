@@ -0,0 +1,158 @@
+package v3
+
+import (
+	"strings"
+	"time"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	v1 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v1"
+	v2 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v2"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/x/zap"
+)
+
+// Convert a v1.0 config into an equivalent v3.0 config.
+// Values are converted as configured, environment variables are neither expanded nor applied.
+func FromV1(conf *v1.Config) *Config {
+	timeout := conf.MongoDB.ConnectionTimeout * time.Second
+	if timeout == 0 {
+		timeout = config.DefaultQueryTimeout
+	}
+
+	// v1.0 logs in console format and an empty level is info
+	level := conf.LogLevel
+	if level == "" {
+		level = "info"
+	}
+
+	converted := &Config{
+		Bind: conf.Bind,
+		Log: zap.Config{
+			Encoding: "console",
+			Level:    level,
+		},
+		Global: Global{
+			QueryTimeout:      timeout,
+			MaxConnections:    conf.MongoDB.MaxConnections,
+			DefaultCache:      time.Duration(conf.MongoDB.DefaultInterval) * time.Second,
+			DefaultDatabase:   conf.MongoDB.DefaultDatabase,
+			DefaultCollection: conf.MongoDB.DefaultCollection,
+		},
+		Servers: []*Server{
+			{
+				Name:           config.DefaultServerName,
+				URI:            conf.MongoDB.URI,
+				ConnectTimeout: timeout,
+			},
+		},
+	}
+
+	// v1.0 metrics are v2.0 metrics bound to the only server
+	var metrics []*v2.Metric
+	for _, metric := range conf.Metrics {
+		metrics = append(metrics, &v2.Metric{
+			Cache:         metric.Cache,
+			Mode:          metric.Mode,
+			Database:      metric.Database,
+			Collection:    metric.Collection,
+			Pipeline:      metric.Pipeline,
+			Name:          metric.Name,
+			Type:          metric.Type,
+			Help:          metric.Help,
+			Value:         metric.Value,
+			OverrideEmpty: metric.OverrideEmpty,
+			EmptyValue:    metric.EmptyValue,
+			ConstLabels:   metric.ConstLabels,
+			Labels:        metric.Labels,
+		})
+	}
+
+	converted.Aggregations = mergeMetrics(metrics)
+	return converted
+}
+
+// Convert a v2.0 config into an equivalent v3.0 config.
+// Values are converted as configured, environment variables are neither expanded nor applied.
+func FromV2(conf *v2.Config) *Config {
+	converted := &Config{
+		Bind:        conf.Bind,
+		MetricsPath: conf.MetricsPath,
+		Log:         conf.Log,
+		Global: Global{
+			QueryTimeout:      conf.Global.QueryTimeout,
+			MaxConnections:    conf.Global.MaxConnections,
+			DefaultCache:      time.Duration(conf.Global.DefaultCache) * time.Second,
+			DefaultMode:       conf.Global.DefaultMode,
+			DefaultDatabase:   conf.Global.DefaultDatabase,
+			DefaultCollection: conf.Global.DefaultCollection,
+		},
+	}
+
+	for _, srv := range conf.Servers {
+		converted.Servers = append(converted.Servers, &Server{
+			Name: srv.Name,
+			URI:  srv.URI,
+		})
+	}
+
+	converted.Aggregations = mergeMetrics(conf.Metrics)
+	return converted
+}
+
+// Merge metrics which are executed with the same pipeline on the same namespace and servers into a single aggregation.
+// Metrics with a different cache or mode are kept in separate aggregations as they are executed differently.
+// The order of the first metric of each aggregation is kept.
+func mergeMetrics(metrics []*v2.Metric) []*Aggregation {
+	type key struct {
+		servers    string
+		cache      int64
+		mode       string
+		database   string
+		collection string
+		pipeline   string
+	}
+
+	var aggregations []*Aggregation
+	merged := make(map[key]*Aggregation)
+
+	for _, metric := range metrics {
+		k := key{
+			servers:    strings.Join(metric.Servers, ","),
+			cache:      metric.Cache,
+			mode:       metric.Mode,
+			database:   metric.Database,
+			collection: metric.Collection,
+			pipeline:   strings.TrimSpace(metric.Pipeline),
+		}
+
+		m := Metric{
+			Name:          metric.Name,
+			Type:          metric.Type,
+			Help:          metric.Help,
+			Value:         metric.Value,
+			OverrideEmpty: metric.OverrideEmpty,
+			EmptyValue:    metric.EmptyValue,
+			ConstLabels:   metric.ConstLabels,
+			Labels:        metric.Labels,
+		}
+
+		if aggregation, ok := merged[k]; ok {
+			aggregation.Metrics = append(aggregation.Metrics, m)
+			continue
+		}
+
+		aggregation := &Aggregation{
+			Servers:    metric.Servers,
+			Cache:      config.LegacyCache(metric.Cache),
+			Mode:       metric.Mode,
+			Database:   metric.Database,
+			Collection: metric.Collection,
+			Pipeline:   metric.Pipeline,
+			Metrics:    []Metric{m},
+		}
+
+		merged[k] = aggregation
+		aggregations = append(aggregations, aggregation)
+	}
+
+	return aggregations
+}
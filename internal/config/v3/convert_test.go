@@ -0,0 +1,86 @@
+package v3
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v1"
+	v2 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v2"
+	"github.com/tj/assert"
+)
+
+func TestFromV1(t *testing.T) {
+	t.Run("Timeouts and caches in seconds are converted to durations", func(t *testing.T) {
+		conf := FromV1(&v1.Config{
+			LogLevel: "debug",
+			MongoDB: v1.MongoDB{
+				URI:               "mongodb://foo:27017",
+				MaxConnections:    3,
+				ConnectionTimeout: 5,
+				DefaultInterval:   30,
+			},
+			Metrics: []*v1.Metric{
+				{Name: "foo", Cache: 60, Database: "db", Collection: "col", Pipeline: "[]"},
+				{Name: "bar", Cache: 60, Database: "db", Collection: "col", Pipeline: "[]"},
+			},
+		})
+
+		assert.Equal(t, "console", conf.Log.Encoding)
+		assert.Equal(t, "debug", conf.Log.Level)
+		assert.Equal(t, 5*time.Second, conf.Global.QueryTimeout)
+		assert.Equal(t, 30*time.Second, conf.Global.DefaultCache)
+		assert.Equal(t, int32(3), conf.Global.MaxConnections)
+		assert.Len(t, conf.Servers, 1)
+		assert.Equal(t, "main", conf.Servers[0].Name)
+		assert.Equal(t, "mongodb://foo:27017", conf.Servers[0].URI)
+		assert.Equal(t, 5*time.Second, conf.Servers[0].ConnectTimeout)
+		assert.Len(t, conf.Aggregations, 1)
+		assert.Equal(t, time.Minute, *conf.Aggregations[0].Cache)
+		assert.Len(t, conf.Aggregations[0].Metrics, 2)
+	})
+
+	t.Run("Defaults of v1.0 are kept", func(t *testing.T) {
+		conf := FromV1(&v1.Config{})
+		assert.Equal(t, "info", conf.Log.Level)
+		assert.Equal(t, 10*time.Second, conf.Global.QueryTimeout)
+		assert.Equal(t, 10*time.Second, conf.Servers[0].ConnectTimeout)
+	})
+}
+
+func TestFromV2(t *testing.T) {
+	t.Run("Metrics with the same servers, namespace and pipeline are merged", func(t *testing.T) {
+		conf := FromV2(&v2.Config{
+			Global: v2.Global{
+				QueryTimeout: time.Minute,
+				DefaultCache: 10,
+			},
+			Servers: []*v2.Server{
+				{Name: "a", URI: "mongodb://a:27017"},
+				{Name: "b", URI: "mongodb://b:27017"},
+			},
+			Metrics: []*v2.Metric{
+				{Name: "foo", Servers: []string{"a"}, Database: "db", Collection: "col", Pipeline: "[]", Value: "total"},
+				{Name: "bar", Servers: []string{"a"}, Database: "db", Collection: "other", Pipeline: "[]"},
+				{Name: "baz", Servers: []string{"a"}, Database: "db", Collection: "col", Pipeline: "[]\n", Value: "count", Labels: []string{"type"}},
+				{Name: "qux", Servers: []string{"b"}, Database: "db", Collection: "col", Pipeline: "[]"},
+				{Name: "quux", Servers: []string{"a"}, Database: "db", Collection: "col", Pipeline: "[]", Cache: 5},
+			},
+		})
+
+		assert.Equal(t, time.Minute, conf.Global.QueryTimeout)
+		assert.Equal(t, 10*time.Second, conf.Global.DefaultCache)
+		assert.Len(t, conf.Servers, 2)
+		assert.Len(t, conf.Aggregations, 4)
+
+		assert.Equal(t, "col", conf.Aggregations[0].Collection)
+		assert.Len(t, conf.Aggregations[0].Metrics, 2)
+		assert.Equal(t, "foo", conf.Aggregations[0].Metrics[0].Name)
+		assert.Equal(t, "baz", conf.Aggregations[0].Metrics[1].Name)
+		assert.Equal(t, []string{"type"}, conf.Aggregations[0].Metrics[1].Labels)
+
+		assert.Equal(t, "other", conf.Aggregations[1].Collection)
+		assert.Equal(t, []string{"b"}, conf.Aggregations[2].Servers)
+		assert.Nil(t, conf.Aggregations[1].Cache)
+		assert.Equal(t, 5*time.Second, *conf.Aggregations[3].Cache)
+	})
+}
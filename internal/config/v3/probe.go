@@ -0,0 +1,49 @@
+package v3
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// Connect to a probe target (MongoDB URI) if all of its hosts are allowed
+func (conf *Config) connectTarget(ctx context.Context, target string) (string, collector.Driver, error) {
+	cs, err := connstring.ParseAndValidate(target)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid target: %w", err)
+	}
+
+	for _, host := range cs.Hosts {
+		if !conf.Probe.allowed(host) {
+			return "", nil, fmt.Errorf("target host %s is not allowed", host)
+		}
+	}
+
+	// The target is used as is, env variables are not substituted
+	opts, err := conf.Probe.Server.buildClientOptions(target, conf.Global)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid options for target: %w", err)
+	}
+
+	d := &collector.MongoDBDriver{}
+	if err := d.Connect(ctx, opts); err != nil {
+		return "", nil, err
+	}
+
+	return strings.Join(cs.Hosts, ","), d, nil
+}
+
+// Reports whether a host matches any of the allowed host patterns
+func (probe *Probe) allowed(host string) bool {
+	for _, pattern := range probe.AllowedHosts {
+		if match, _ := path.Match(pattern, host); match {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,175 @@
+package v3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// Resolve where the connection URI of a server is read from.
+// MDBEXPORTER_SERVER_%d_MONGODB_URI takes precedence over MDBEXPORTER_SERVER_%d_MONGODB_URI_FILE
+// which takes precedence over the server config.
+func (srv *Server) resolveURI(id int) error {
+	if env := os.Getenv(fmt.Sprintf("MDBEXPORTER_SERVER_%d_MONGODB_URI", id)); env != "" {
+		srv.URI = env
+		srv.URIFile = ""
+	} else if env := os.Getenv(fmt.Sprintf("MDBEXPORTER_SERVER_%d_MONGODB_URI_FILE", id)); env != "" {
+		srv.URI = ""
+		srv.URIFile = env
+	}
+
+	if srv.URI != "" && srv.URIFile != "" {
+		return fmt.Errorf("uri and uriFile are mutually exclusive")
+	}
+
+	if (srv.UsernameFile == "") != (srv.PasswordFile == "") {
+		return fmt.Errorf("usernameFile and passwordFile must be set together")
+	}
+
+	if srv.URI == "" && srv.URIFile == "" {
+		srv.URI = config.DefaultMongoDBURI
+	}
+
+	return nil
+}
+
+// Build the client options of a server.
+// The URI and credentials are read from their files if configured.
+func (srv *Server) clientOptions(global Global) (*options.ClientOptions, error) {
+	uri := os.ExpandEnv(srv.URI)
+	if srv.URIFile != "" {
+		b, err := readSecretFile(srv.URIFile)
+		if err != nil {
+			return nil, err
+		}
+
+		uri = b
+	}
+
+	return srv.buildClientOptions(uri, global)
+}
+
+// Build the client options for a server connecting to the given URI
+func (srv *Server) buildClientOptions(uri string, global Global) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri)
+
+	if srv.UsernameFile != "" {
+		username, err := readSecretFile(srv.UsernameFile)
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := readSecretFile(srv.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep auth source and mechanism from the URI, the driver ignores them if the URI has no username
+		var credential options.Credential
+		if opts.Auth != nil {
+			credential = *opts.Auth
+		} else if cs, err := connstring.Parse(uri); err == nil {
+			credential.AuthSource = cs.AuthSource
+			credential.AuthMechanism = cs.AuthMechanism
+			credential.AuthMechanismProperties = cs.AuthMechanismProperties
+		}
+
+		credential.Username = username
+		credential.Password = password
+		credential.PasswordSet = true
+		opts.SetAuth(credential)
+	}
+
+	if err := srv.applyOptions(opts, global); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// Files the connection settings of a server are read from
+func (srv *Server) secretFiles() []string {
+	var files []string
+	for _, path := range []string{srv.URIFile, srv.UsernameFile, srv.PasswordFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+
+	return files
+}
+
+// Read the content of all secret files, used to detect changes
+func (srv *Server) readSecretFiles() (string, error) {
+	var content []string
+	for _, path := range srv.secretFiles() {
+		b, err := readSecretFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		content = append(content, b)
+	}
+
+	return strings.Join(content, "\x00"), nil
+}
+
+// Periodically re-read the secret files of a server and reconnect the driver if any of them changed.
+// This allows rotating credentials without restarting the exporter.
+func (srv *Server) watchSecretFiles(ctx context.Context, name string, global Global, d collector.Driver, logger collector.Logger, interval time.Duration) {
+	current, err := srv.readSecretFiles()
+	if err != nil {
+		logger.Errorf("failed to read secret files of server %s: %s", name, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		content, err := srv.readSecretFiles()
+		if err != nil {
+			logger.Errorf("failed to read secret files of server %s: %s", name, err)
+			continue
+		}
+
+		if content == current {
+			continue
+		}
+
+		opts, err := srv.clientOptions(global)
+		if err != nil {
+			logger.Errorf("failed to build options for server %s: %s", name, err)
+			continue
+		}
+
+		if err := d.Connect(ctx, opts); err != nil {
+			logger.Errorf("failed to reconnect to server %s: %s", name, err)
+			continue
+		}
+
+		logger.Infof("secret files of server %s changed, reconnected", name)
+		current = content
+	}
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
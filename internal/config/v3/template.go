@@ -0,0 +1,180 @@
+package v3
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+)
+
+// A reusable aggregation which is instantiated by aggregations referencing the template by name.
+// All strings of the aggregation (like the pipeline, database, collection and metric labels) may contain
+// parameters like {{ .tenant }} which are replaced with the params of the instantiating aggregation.
+type Template struct {
+	Name string `yaml:"name,omitempty"`
+	// Default values of the parameters
+	Params      map[string]string `yaml:"params,omitempty"`
+	Aggregation Aggregation       `yaml:"aggregation,omitempty"`
+}
+
+// Expand aggregations which reference a template into ordinary aggregations.
+// Errors are reported with the location of the template or the instantiating aggregation,
+// aggregations which can not be instantiated are nil to keep the locations of the others.
+func (conf *Config) instantiateTemplates(errs *config.ValidationErrors) []*Aggregation {
+	templates := make(map[string]*Template)
+	for i, tpl := range conf.Templates {
+		location := fmt.Sprintf("templates[%d]", i)
+		switch {
+		case tpl.Name == "":
+			errs.Add(location, fmt.Errorf("template name is required"))
+		case templates[tpl.Name] != nil:
+			errs.Add(location, fmt.Errorf("template %s is already defined", tpl.Name))
+		case tpl.Aggregation.Template != "":
+			errs.Add(location, fmt.Errorf("template %s may not instantiate another template", tpl.Name))
+		default:
+			templates[tpl.Name] = tpl
+		}
+	}
+
+	aggregations := make([]*Aggregation, 0, len(conf.Aggregations))
+	for i, aggregation := range conf.Aggregations {
+		if aggregation.Template == "" {
+			aggregations = append(aggregations, aggregation)
+			continue
+		}
+
+		location := fmt.Sprintf("aggregations[%d]", i)
+		tpl, ok := templates[aggregation.Template]
+		if !ok {
+			errs.Add(location, fmt.Errorf("unknown template %s", aggregation.Template))
+			aggregations = append(aggregations, nil)
+			continue
+		}
+
+		instance, err := tpl.instantiate(aggregation)
+		if err != nil {
+			errs.Add(location, err)
+			aggregations = append(aggregations, nil)
+			continue
+		}
+
+		aggregations = append(aggregations, instance)
+	}
+
+	return aggregations
+}
+
+// Render the template with the params of the aggregation, settings of the aggregation override the template
+func (tpl *Template) instantiate(aggregation *Aggregation) (*Aggregation, error) {
+	params := make(map[string]string, len(tpl.Params)+len(aggregation.Params))
+	for k, v := range tpl.Params {
+		params[k] = v
+	}
+
+	for k, v := range aggregation.Params {
+		params[k] = v
+	}
+
+	instance := &Aggregation{}
+	rendered, err := render(reflect.ValueOf(tpl.Aggregation), params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", tpl.Name, err)
+	}
+
+	reflect.ValueOf(instance).Elem().Set(rendered)
+
+	src := reflect.ValueOf(aggregation).Elem()
+	dst := reflect.ValueOf(instance).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if name := src.Type().Field(i).Name; name == "Template" || name == "Params" {
+			continue
+		}
+
+		if field := src.Field(i); !field.IsZero() {
+			dst.Field(i).Set(field)
+		}
+	}
+
+	return instance, nil
+}
+
+// Return a copy of the value with all strings (including those in slices, maps and structs) rendered with the params
+func render(v reflect.Value, params map[string]string) (reflect.Value, error) {
+	rendered := reflect.New(v.Type()).Elem()
+
+	switch v.Kind() {
+	case reflect.String:
+		s, err := renderString(v.String(), params)
+		if err != nil {
+			return rendered, err
+		}
+
+		rendered.SetString(s)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !rendered.Field(i).CanSet() {
+				continue
+			}
+
+			field, err := render(v.Field(i), params)
+			if err != nil {
+				return rendered, err
+			}
+
+			rendered.Field(i).Set(field)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return rendered, nil
+		}
+
+		rendered.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			item, err := render(v.Index(i), params)
+			if err != nil {
+				return rendered, err
+			}
+
+			rendered.Index(i).Set(item)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return rendered, nil
+		}
+
+		rendered.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := render(iter.Value(), params)
+			if err != nil {
+				return rendered, err
+			}
+
+			rendered.SetMapIndex(iter.Key(), value)
+		}
+	default:
+		rendered.Set(v)
+	}
+
+	return rendered, nil
+}
+
+func renderString(s string, params map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, params); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
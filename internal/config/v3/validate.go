@@ -0,0 +1,151 @@
+package v3
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/collector"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"github.com/raffis/mongodb-query-exporter/v5/internal/x/zap"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Run all checks of Build without connecting to any server, the config is not modified.
+// All errors are returned with the location of the invalid setting.
+// Referenced files are only read if CheckFiles is set.
+func (conf *Config) Validate(opts config.ValidateOptions) error {
+	var errs config.ValidationErrors
+
+	log := conf.Log
+	if log.Encoding == "" {
+		log.Encoding = config.DefaultLogEncoder
+	}
+
+	if log.Level == "" {
+		log.Level = config.DefaultLogLevel
+	}
+
+	if _, err := zap.New(log); err != nil {
+		errs.Add("log", err)
+	}
+
+	if config.IsReservedPath(conf.MetricsPath) {
+		errs.Add("metricsPath", fmt.Errorf("%s not allowed as metrics path", conf.MetricsPath))
+	}
+
+	aggregations := conf.instantiateTemplates(&errs)
+	conf.validateMetricsPaths(&errs, aggregations)
+
+	servers := conf.Servers
+	if len(servers) == 0 {
+		servers = []*Server{{Name: config.DefaultServerName}}
+	}
+
+	c := collector.New(collector.WithConfig(conf.collectorConfig()))
+	for id, srv := range servers {
+		location := fmt.Sprintf("servers[%d]", id)
+
+		// Resolving the URI modifies the server
+		srv := *srv
+		if err := srv.resolveURI(id); err != nil {
+			errs.Add(location, err)
+			continue
+		}
+
+		clientOpts, err := srv.validationOptions(conf.Global, opts.CheckFiles)
+		if err != nil {
+			errs.Add(location, err)
+			continue
+		}
+
+		// The hosts of a URI file which has not been read are unknown
+		name := srv.serverName(clientOpts)
+		if name == "" {
+			name = srv.URIFile
+		}
+
+		errs.Add(location, c.RegisterServer(name, &collector.MongoDBDriver{}))
+	}
+
+	for i, pattern := range conf.Probe.AllowedHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs.Add(fmt.Sprintf("probe.allowedHosts[%d]", i), err)
+		}
+	}
+
+	if len(conf.Probe.AllowedHosts) > 0 {
+		var err error
+		probe := &conf.Probe.Server
+		if !opts.CheckFiles {
+			probe, err = probe.withoutFiles()
+		}
+
+		if err == nil {
+			err = probe.applyOptions(options.Client(), conf.Global)
+		}
+
+		errs.Add("probe.server", err)
+	}
+
+	for i, aggregation := range aggregations {
+		if aggregation == nil {
+			continue
+		}
+
+		location := fmt.Sprintf("aggregations[%d]", i)
+		aggregation := aggregation.build()
+
+		// Invalid metrics are reported individually, the aggregation is registered with the valid ones
+		var metrics []*collector.Metric
+		for j, metric := range aggregation.Metrics {
+			if err := metric.Validate(); err != nil {
+				errs.Add(fmt.Sprintf("%s.metrics[%d]", location, j), err)
+				continue
+			}
+
+			metrics = append(metrics, metric)
+		}
+
+		aggregation.Metrics = metrics
+		errs.Add(location, c.RegisterAggregation(aggregation))
+	}
+
+	return errs.Err()
+}
+
+// Build the client options of a server with a resolved URI for validation.
+// Unless files are checked no files are read and a URI read from a file is not validated.
+func (srv *Server) validationOptions(global Global, checkFiles bool) (*options.ClientOptions, error) {
+	if checkFiles {
+		return srv.clientOptions(global)
+	}
+
+	offline, err := srv.withoutFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	if srv.URIFile != "" {
+		opts := options.Client()
+		return opts, offline.applyOptions(opts, global)
+	}
+
+	return offline.buildClientOptions(os.ExpandEnv(srv.URI), global)
+}
+
+// A copy of the server without the credential and tls files, the tls settings are checked without reading the files
+func (srv *Server) withoutFiles() (*Server, error) {
+	if srv.TLS != nil {
+		if err := srv.TLS.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid tls config: %w", err)
+		}
+	}
+
+	offline := *srv
+	offline.UsernameFile = ""
+	offline.PasswordFile = ""
+	offline.TLS = nil
+	return &offline, nil
+}
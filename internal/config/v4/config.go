@@ -0,0 +1,11 @@
+package v4
+
+import (
+	v3 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v3"
+)
+
+// Configuration v4.0 format.
+// It has the same settings as the v3.0 format but is decoded strictly, unknown keys are rejected (see Strict).
+type Config struct {
+	v3.Config `mapstructure:",squash" yaml:",inline"`
+}
@@ -0,0 +1,95 @@
+package v4
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"github.com/tj/assert"
+)
+
+func TestStrict(t *testing.T) {
+	t.Run("Known keys are accepted", func(t *testing.T) {
+		b, err := os.ReadFile("../../../example/configv4.yaml")
+		assert.NoError(t, err)
+		assert.NoError(t, Strict(b))
+	})
+
+	t.Run("Unknown keys are reported with their path", func(t *testing.T) {
+		err := Strict([]byte(`version: 4.0
+global:
+  maxConnection: 3
+servers:
+- name: main
+  tls:
+    caFil: /ca.crt
+aggregations:
+- Database: db
+  metrics:
+  - name: foo
+    constLabels:
+      anyLabel: foo
+  - name: bar
+    foo: bar
+`))
+
+		errs, ok := err.(config.ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 4)
+		assert.Equal(t, `global.maxConnection: unknown key "maxConnection" on line 3, did you mean "maxConnections"?`, errs[0].Error())
+		assert.Equal(t, `servers[0].tls.caFil: unknown key "caFil" on line 7, did you mean "caFile"?`, errs[1].Error())
+		assert.Equal(t, `aggregations[0].Database: unknown key "Database" on line 9, did you mean "database"?`, errs[2].Error())
+		assert.Equal(t, `aggregations[0].metrics[1].foo: unknown key "foo" on line 15`, errs[3].Error())
+	})
+
+	t.Run("Merged keys are checked", func(t *testing.T) {
+		err := Strict([]byte(`version: 4.0
+aggregations:
+- &defaults
+  databse: db
+  collection: foo
+- <<: *defaults
+  collection: bar
+`))
+
+		errs, ok := err.(config.ValidationErrors)
+		assert.True(t, ok)
+		assert.Len(t, errs, 2)
+		assert.Equal(t, "aggregations[0].databse", errs[0].Path)
+		assert.Equal(t, "aggregations[1].databse", errs[1].Path)
+	})
+}
+
+func TestSchema(t *testing.T) {
+	t.Run("The published schema is up to date", func(t *testing.T) {
+		expected, err := SchemaJSON()
+		assert.NoError(t, err)
+
+		b, err := os.ReadFile("../../../schema/config-v4.json")
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(expected, b), "schema/config-v4.json is outdated, run make schema")
+	})
+
+	t.Run("Unknown properties are not allowed", func(t *testing.T) {
+		schema := Schema()
+		assert.Equal(t, false, schema["additionalProperties"])
+
+		properties := schema["properties"].(map[string]interface{})
+		assert.Contains(t, properties, "version")
+		assert.Contains(t, properties, "aggregations")
+		assert.NotContains(t, properties, "Version")
+	})
+}
+
+func TestCamelCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Name":            "name",
+		"URI":             "uri",
+		"URIFile":         "uriFile",
+		"TLS":             "tls",
+		"MaxConnIdleTime": "maxConnIdleTime",
+	} {
+		assert.Equal(t, expected, camelCase(name))
+	}
+}
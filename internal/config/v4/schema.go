@@ -0,0 +1,140 @@
+package v4
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	v3 "github.com/raffis/mongodb-query-exporter/v5/internal/config/v3"
+)
+
+// The document of a config file, the version is not part of the config itself
+type document struct {
+	Version   float32 `yaml:"version"`
+	v3.Config `yaml:",inline"`
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Durations are either strings like 1m30s or nanoseconds
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$`
+
+// The JSON Schema of the v4.0 format, generated from the config types
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(document{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "mongodb-query-exporter config v4.0"
+	schema["required"] = []string{"version"}
+	schema["properties"].(map[string]interface{})["version"] = map[string]interface{}{
+		"const": 4,
+	}
+
+	return schema
+}
+
+// The JSON Schema of the v4.0 format as indented JSON
+func SchemaJSON() ([]byte, error) {
+	b, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == durationType {
+		return map[string]interface{}{
+			"type":    []string{"string", "integer"},
+			"pattern": durationPattern,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for key, field := range fields(t) {
+			properties[key] = typeSchema(field)
+		}
+
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// The config keys of a struct and their types.
+// Keys are taken from the yaml tags, fields without tag are keyed by their camel cased name.
+// Inlined and embedded structs add their keys to the parent.
+func fields(t reflect.Type) map[string]reflect.Type {
+	keys := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous || strings.Contains(opts, "inline") {
+			for key, typ := range fields(field.Type) {
+				keys[key] = typ
+			}
+
+			continue
+		}
+
+		if name == "" {
+			name = camelCase(field.Name)
+		}
+
+		keys[name] = field.Type
+	}
+
+	return keys
+}
+
+// Lower the leading upper case letters of a Go name (URIFile becomes uriFile)
+func camelCase(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) || (i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			break
+		}
+
+		runes[i] = unicode.ToLower(runes[i])
+	}
+
+	return string(runes)
+}
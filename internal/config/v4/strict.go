@@ -0,0 +1,139 @@
+package v4
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/raffis/mongodb-query-exporter/v5/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Check a v4.0 config file for unknown keys.
+// All unknown keys are reported with their location in the config (like global.maxConnection).
+// Only keys are checked, values are checked when the config is decoded.
+func Strict(b []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	var errs config.ValidationErrors
+	for _, node := range doc.Content {
+		checkKeys(&errs, "", node, reflect.TypeOf(document{}))
+	}
+
+	return errs.Err()
+}
+
+func checkKeys(errs *config.ValidationErrors, path string, node *yaml.Node, t reflect.Type) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		keys := fields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			// Merged keys are checked as if they were part of the mapping
+			if key.Value == "<<" {
+				checkKeys(errs, path, value, t)
+				continue
+			}
+
+			typ, ok := keys[key.Value]
+			if !ok {
+				errs.Add(joinPath(path, key.Value), unknownKey(key, keys))
+				continue
+			}
+
+			checkKeys(errs, joinPath(path, key.Value), value, typ)
+		}
+
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKeys(errs, joinPath(path, node.Content[i].Value), node.Content[i+1], t.Elem())
+		}
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			checkKeys(errs, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())
+		}
+
+	// Merge keys may refer to a sequence of mappings
+	case t.Kind() == reflect.Struct && node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			checkKeys(errs, path, item, t)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// Report the unknown key and suggest a known key with a similar name
+func unknownKey(key *yaml.Node, keys map[string]reflect.Type) error {
+	suggestion, distance := "", 3
+	for known := range keys {
+		if strings.EqualFold(known, key.Value) {
+			suggestion = known
+			break
+		}
+
+		if d := levenshtein(known, key.Value); d < distance || (d == distance && known < suggestion) {
+			suggestion, distance = known, d
+		}
+	}
+
+	if suggestion == "" {
+		return fmt.Errorf("unknown key %q on line %d", key.Value, key.Line)
+	}
+
+	return fmt.Errorf("unknown key %q on line %d, did you mean %q?", key.Value, key.Line, suggestion)
+}
+
+// The number of edits to turn a into b
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	row := make([]int, len(t)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+
+			cur := row[j]
+			row[j] = prev + cost
+			if row[j-1]+1 < row[j] {
+				row[j] = row[j-1] + 1
+			}
+
+			if cur+1 < row[j] {
+				row[j] = cur + 1
+			}
+
+			prev = cur
+		}
+	}
+
+	return row[len(t)]
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options of a config validation
+type ValidateOptions struct {
+	// Read the files referenced by the config (like secret files and tls certificates).
+	// If not set only the settings referencing files are checked.
+	CheckFiles bool
+}
+
+// An invalid setting and its location in the config file (like aggregations[0].metrics[1]).
+// The file is only set if the config is split across multiple files.
+type ValidationError struct {
+	File string
+	Path string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Err.Error()
+	if e.Path != "" {
+		msg = fmt.Sprintf("%s: %s", e.Path, msg)
+	}
+
+	if e.File != "" {
+		msg = fmt.Sprintf("%s: %s", e.File, msg)
+	}
+
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// All invalid settings found in a config
+type ValidationErrors []*ValidationError
+
+// Add an error at the given location, nil errors are ignored.
+// Joined errors are added individually with the same location.
+func (errs *ValidationErrors) Add(path string, err error) {
+	if err == nil {
+		return
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			errs.Add(path, err)
+		}
+
+		return
+	}
+
+	*errs = append(*errs, &ValidationError{Path: path, Err: err})
+}
+
+func (errs ValidationErrors) Error() string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Returns nil if no errors have been added
+func (errs ValidationErrors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
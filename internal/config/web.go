@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// HTTP server TLS and basic auth settings.
+// These are written to an exporter-toolkit web config file.
+type Web struct {
+	TLSServerConfig *WebTLS         `yaml:"tlsServerConfig,omitempty"`
+	BasicAuthUsers  []BasicAuthUser `yaml:"basicAuthUsers,omitempty"`
+}
+
+// TLS settings of the HTTP server
+type WebTLS struct {
+	CertFile       string `yaml:"certFile,omitempty"`
+	KeyFile        string `yaml:"keyFile,omitempty"`
+	ClientAuthType string `yaml:"clientAuthType,omitempty"`
+	ClientCAFile   string `yaml:"clientCAFile,omitempty"`
+	MinVersion     string `yaml:"minVersion,omitempty"`
+}
+
+// A basic auth user with a bcrypt hashed password
+type BasicAuthUser struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// Write the web config in the exporter-toolkit format to a temporary file and return its path.
+// The caller is responsible to remove the file.
+func (w *Web) WriteFile() (string, error) {
+	conf := make(map[string]interface{})
+
+	if w.TLSServerConfig != nil {
+		tls := map[string]string{}
+		for key, path := range map[string]string{
+			"cert_file":      w.TLSServerConfig.CertFile,
+			"key_file":       w.TLSServerConfig.KeyFile,
+			"client_ca_file": w.TLSServerConfig.ClientCAFile,
+		} {
+			if path == "" {
+				continue
+			}
+
+			// Relative paths would be resolved relative to the temporary file
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return "", err
+			}
+
+			tls[key] = abs
+		}
+
+		if w.TLSServerConfig.ClientAuthType != "" {
+			tls["client_auth_type"] = w.TLSServerConfig.ClientAuthType
+		}
+
+		if w.TLSServerConfig.MinVersion != "" {
+			tls["min_version"] = w.TLSServerConfig.MinVersion
+		}
+
+		conf["tls_server_config"] = tls
+	}
+
+	if len(w.BasicAuthUsers) > 0 {
+		users := make(map[string]string)
+		for _, user := range w.BasicAuthUsers {
+			users[user.Username] = user.Password
+		}
+
+		conf["basic_auth_users"] = users
+	}
+
+	// JSON is valid YAML
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "mongodb-query-exporter-web-*.yml")
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,184 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// CAFile is the path to a PEM encoded CA bundle used to verify the server certificate.
+	// If empty the system roots are used.
+	CAFile string `json:"caFile" yaml:"caFile"`
+	// CertFile is the path to a PEM encoded client certificate (x.509 authentication).
+	CertFile string `json:"certFile" yaml:"certFile"`
+	// KeyFile is the path to the PEM encoded private key of the client certificate.
+	KeyFile string `json:"keyFile" yaml:"keyFile"`
+	// InsecureSkipVerify disables the verification of the server certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+	// ServerName is used to verify the hostname of the server certificate.
+	// If empty the hostname of the server is used, servers addressed by an IP require a server name if CAFile is set.
+	ServerName string `json:"serverName" yaml:"serverName"`
+}
+
+// Check the settings without reading the files
+func (config Config) Validate() error {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return errors.New("certFile and keyFile must be set together")
+	}
+
+	return nil
+}
+
+// Keeps the CA pool and client certificate in sync with the files on disk
+type reloader struct {
+	config   Config
+	mutex    sync.Mutex
+	modTimes map[string]time.Time
+	caPool   *x509.CertPool
+	cert     *tls.Certificate
+}
+
+// Initializes a tls config from the given files.
+// The CA and client certificate files are checked for changes during each handshake and reloaded
+// if they have been modified, meaning rotated certificates are used without a restart.
+func New(config Config) (*tls.Config, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &reloader{
+		config:   config,
+		modTimes: make(map[string]time.Time),
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	c := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CertFile != "" {
+		c.GetClientCertificate = r.getClientCertificate
+	}
+
+	// The default verification uses a static root pool, hence the verification is done
+	// manually against the current CA pool
+	if config.CAFile != "" && !config.InsecureSkipVerify {
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = r.verifyConnection
+	}
+
+	return c, nil
+}
+
+// Load the files from disk
+func (r *reloader) load() error {
+	if r.config.CAFile != "" {
+		pem, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificates found in ca file %s", r.config.CAFile)
+		}
+
+		r.caPool = pool
+	}
+
+	if r.config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+
+		r.cert = &cert
+	}
+
+	for _, path := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if path == "" {
+			continue
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			r.modTimes[path] = info.ModTime()
+		}
+	}
+
+	return nil
+}
+
+// Reload the files if any of them has been modified.
+// If a reload fails the previously loaded certificates are kept.
+func (r *reloader) reload() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changed := false
+	for path, modTime := range r.modTimes {
+		if info, err := os.Stat(path); err == nil && !info.ModTime().Equal(modTime) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	caPool, cert := r.caPool, r.cert
+	if err := r.load(); err != nil {
+		r.caPool, r.cert = caPool, cert
+	}
+}
+
+func (r *reloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.reload()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cert, nil
+}
+
+func (r *reloader) verifyConnection(cs tls.ConnectionState) error {
+	r.reload()
+
+	r.mutex.Lock()
+	roots := r.caPool
+	r.mutex.Unlock()
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificate provided")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// No SNI is sent for IP addresses, meaning the server name of the connection is empty for IP hosts
+	name := r.config.ServerName
+	if name == "" {
+		name = cs.ServerName
+	}
+
+	if name == "" {
+		return errors.New("the hostname of the server certificate can not be verified without a server name, set serverName if the server is addressed by its ip")
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+
+	return err
+}
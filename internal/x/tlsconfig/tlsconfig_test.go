@@ -0,0 +1,122 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+func writeCertificate(t *testing.T, dir, commonName string, modTime time.Time, ips ...net.IP) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	assert.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	assert.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Cert file without key file fails", func(t *testing.T) {
+		_, err := New(Config{CertFile: "tls.crt"})
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid ca file fails", func(t *testing.T) {
+		dir := t.TempDir()
+		caFile := filepath.Join(dir, "ca.crt")
+		assert.NoError(t, os.WriteFile(caFile, []byte("foo"), 0600))
+
+		_, err := New(Config{CAFile: caFile})
+		assert.Error(t, err)
+	})
+
+	t.Run("Ca file enables custom verification", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCertificate(t, dir, "ca", time.Now())
+
+		c, err := New(Config{CAFile: filepath.Join(dir, "tls.crt")})
+		assert.NoError(t, err)
+		assert.True(t, c.InsecureSkipVerify)
+		assert.NotNil(t, c.VerifyConnection)
+	})
+
+	t.Run("Server certificate of an ip host is verified against the server name", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCertificate(t, dir, "mongodb", time.Now(), net.ParseIP("127.0.0.1"))
+
+		b, err := os.ReadFile(filepath.Join(dir, "tls.crt"))
+		assert.NoError(t, err)
+		block, _ := pem.Decode(b)
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		assert.NoError(t, err)
+
+		// Go sends no SNI for ip hosts, hence the server name of the connection is empty
+		state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+
+		for serverName, valid := range map[string]bool{
+			"":          false,
+			"127.0.0.1": true,
+			"10.0.0.1":  false,
+		} {
+			c, err := New(Config{CAFile: filepath.Join(dir, "tls.crt"), ServerName: serverName})
+			assert.NoError(t, err)
+
+			err = c.VerifyConnection(state)
+			assert.Equal(t, valid, err == nil, serverName)
+		}
+	})
+
+	t.Run("Client certificate is reloaded once the files change", func(t *testing.T) {
+		dir := t.TempDir()
+		writeCertificate(t, dir, "first", time.Now().Add(-time.Minute))
+
+		c, err := New(Config{
+			CertFile: filepath.Join(dir, "tls.crt"),
+			KeyFile:  filepath.Join(dir, "tls.key"),
+		})
+		assert.NoError(t, err)
+
+		cert, err := c.GetClientCertificate(nil)
+		assert.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "first", leaf.Subject.CommonName)
+
+		writeCertificate(t, dir, "second", time.Now())
+
+		cert, err = c.GetClientCertificate(nil)
+		assert.NoError(t, err)
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		assert.NoError(t, err)
+		assert.Equal(t, "second", leaf.Subject.CommonName)
+	})
+}